@@ -0,0 +1,63 @@
+package dnsresolvr
+
+import "time"
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker tracks the consecutive failures of one upstream server. Once they reach the
+// threshold the breaker opens and the server is skipped until openUntil.
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+func (r *Resolver) breakerThreshold() int {
+	if r.BreakerThreshold == 0 {
+		return defaultBreakerThreshold
+	}
+	return r.BreakerThreshold
+}
+
+func (r *Resolver) breakerCooldown() time.Duration {
+	if r.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return r.BreakerCooldown
+}
+
+// isServerAvailable reports whether the breaker of server is closed, or its cooldown has
+// elapsed so that the server may be tried again.
+func (r *Resolver) isServerAvailable(server string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	breaker, ok := r.breakers[server]
+	if !ok || r.breakerThreshold() < 0 {
+		return true
+	}
+	return !r.clock().Now().Before(breaker.openUntil)
+}
+
+// recordServerResult updates the breaker of server with the outcome of a query to it.
+func (r *Resolver) recordServerResult(server string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !failed {
+		delete(r.breakers, server)
+		return
+	}
+	if r.breakers == nil {
+		r.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := r.breakers[server]
+	if !ok {
+		breaker = &circuitBreaker{}
+		r.breakers[server] = breaker
+	}
+	breaker.failures++
+	if breaker.failures >= r.breakerThreshold() {
+		breaker.openUntil = r.clock().Now().Add(r.breakerCooldown())
+	}
+}
@@ -0,0 +1,45 @@
+package dnsresolvr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerSkipsFailingServer(t *testing.T) {
+	failing := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{rcode: ServerFailure}.bytesFor(query)
+	})
+	healthy := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	resolver := &Resolver{
+		Servers:          []string{failing.address(), healthy.address()},
+		Clock:            clock,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	}
+	resolve := func() {
+		t.Helper()
+		response, err := resolver.Resolve("www.example.com")
+		if err != nil || len(response.Answers) != 1 {
+			t.Fatalf("Got: %v (%v), Want: answer from the healthy server", response, err)
+		}
+	}
+	resolve()
+	resolve()
+	if len(failing.receivedQueries()) != 2 {
+		t.Fatalf("Got %d queries to the failing server, Want: 2", len(failing.receivedQueries()))
+	}
+	resolve()
+	if len(failing.receivedQueries()) != 2 {
+		t.Fatalf("Got %d queries to the failing server, Want: skipped while the breaker is open",
+			len(failing.receivedQueries()))
+	}
+	clock.Advance(time.Minute)
+	resolve()
+	if len(failing.receivedQueries()) != 3 {
+		t.Fatalf("Got %d queries to the failing server, Want: tried again after the cooldown",
+			len(failing.receivedQueries()))
+	}
+}
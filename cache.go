@@ -0,0 +1,117 @@
+package dnsresolvr
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	domainName string
+	qtype      MessageType
+	qclass     MessageClass
+}
+
+type cacheEntry struct {
+	response  *DnsResponse
+	expiresAt time.Time
+	ttl       time.Duration
+	// refreshing is set once a lookup has been told to refresh the entry, so that only one
+	// refresh is started.
+	refreshing bool
+}
+
+// Cache keeps responses until the lowest TTL among their answers runs out. The zero value
+// is an empty cache that uses the real clock. It is safe for concurrent use.
+type Cache struct {
+	// Clock is used to compute and check expiry. Defaults to the system clock.
+	Clock Clock
+	// PrefetchThreshold is the fraction of its TTL an entry has left when the Resolver starts
+	// resolving it again in the background on access, while still serving the cached
+	// response, so that popular entries are replaced before they expire. Zero disables it.
+	PrefetchThreshold float64
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func (c *Cache) now() time.Time {
+	if c.Clock == nil {
+		return realClock{}.Now()
+	}
+	return c.Clock.Now()
+}
+
+func newCacheKey(domainName string, qtype MessageType, qclass MessageClass) cacheKey {
+	return cacheKey{
+		domainName: strings.ToLower(strings.TrimSuffix(domainName, ".")),
+		qtype:      qtype,
+		qclass:     qclass,
+	}
+}
+
+// Get returns the cached response for the question. Expired entries are evicted and
+// reported as missing.
+func (c *Cache) Get(domainName string, qtype MessageType, qclass MessageClass) (*DnsResponse, bool) {
+	response, ok, _ := c.lookup(domainName, qtype, qclass)
+	return response, ok
+}
+
+// lookup is Get that also reports whether the entry is within PrefetchThreshold of its expiry
+// and should be refreshed. Only the first lookup to find an entry there is told so. Should
+// that refresh fail, the entry is left to expire.
+func (c *Cache) lookup(domainName string, qtype MessageType, qclass MessageClass) (*DnsResponse, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := newCacheKey(domainName, qtype, qclass)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	remaining := entry.expiresAt.Sub(c.now())
+	if remaining <= 0 {
+		delete(c.entries, key)
+		return nil, false, false
+	}
+	refresh := false
+	if !entry.refreshing && float64(remaining) <= c.PrefetchThreshold*float64(entry.ttl) {
+		refresh = true
+		entry.refreshing = true
+		c.entries[key] = entry
+	}
+	return entry.response, true, refresh
+}
+
+// Put caches the response under its question. Responses without answers and truncated
+// responses, whose answers may be incomplete, are not cached.
+func (c *Cache) Put(response *DnsResponse) {
+	if response.Question == nil || len(response.Answers) == 0 || response.Header.IsTruncatedMessage {
+		return
+	}
+	ttl := response.Answers[0].TTL
+	for _, answer := range response.Answers[1:] {
+		ttl = min(ttl, answer.TTL)
+	}
+	if ttl == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[cacheKey]cacheEntry)
+	}
+	key := newCacheKey(response.Question.GetDomainName(), response.Question.Qtype, response.Question.Qclass)
+	c.entries[key] = cacheEntry{
+		response:  response,
+		expiresAt: c.now().Add(time.Duration(ttl) * time.Second),
+		ttl:       time.Duration(ttl) * time.Second,
+	}
+}
+
+// Len returns the number of entries in the cache, including ones that expired but haven't
+// been evicted yet.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
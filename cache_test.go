@@ -0,0 +1,112 @@
+package dnsresolvr
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestCacheEvictsExpiredEntries(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cache := &Cache{Clock: clock}
+	query := generateDnsQuery("example.com", A)
+	cache.Put(&DnsResponse{
+		Header:   &query.Header,
+		Question: &query.Questions[0],
+		Answers:  []DnsAnswer{{Domain: "example.com", RecordType: A, Address: "93.184.216.34", TTL: 60}},
+	})
+	clock.Advance(59 * time.Second)
+	if _, ok := cache.Get("example.com", A, IN); !ok {
+		t.Fatalf("Got cache miss, Want: hit before the TTL elapsed")
+	}
+	clock.Advance(time.Second)
+	if _, ok := cache.Get("example.com", A, IN); ok {
+		t.Fatalf("Got cache hit, Want: miss once the TTL elapsed")
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Got %d entries, Want: expired entry evicted", cache.Len())
+	}
+}
+
+func TestResolverUsesCache(t *testing.T) {
+	hierarchy := startMockHierarchy(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	resolver := hierarchy.newResolver()
+	resolver.Clock = clock
+	resolver.Cache = &Cache{Clock: clock}
+	for i := 0; i < 2; i++ {
+		_, err := resolver.ResolveIterative("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(hierarchy.example.receivedQueries()) != 1 {
+		t.Fatalf("Got %d queries, Want: second resolution served from cache", len(hierarchy.example.receivedQueries()))
+	}
+	clock.Advance(301 * time.Second)
+	_, err := resolver.ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(hierarchy.example.receivedQueries()) != 2 {
+		t.Fatalf("Got %d queries, Want: expired entry resolved again", len(hierarchy.example.receivedQueries()))
+	}
+}
+
+func TestCachePrefetchesEntriesNearExpiry(t *testing.T) {
+	var queries atomic.Int32
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		address := "192.0.2." + strconv.Itoa(int(queries.Add(1)))
+		return testReply{answers: []testRecord{aRecord("www.example.com", address)}}.bytesFor(query)
+	})
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	resolver := &Resolver{
+		Servers: []string{server.address()},
+		Clock:   clock,
+		Cache:   &Cache{Clock: clock, PrefetchThreshold: 0.1},
+	}
+	resolve := func() string {
+		t.Helper()
+		response, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return response.Answers[0].Address
+	}
+	resolve()
+	clock.Advance(200 * time.Second)
+	if got := resolve(); got != "192.0.2.1" || queries.Load() != 1 {
+		t.Fatalf("Got %s after %d queries, Want: 192.0.2.1 from the cache without a refresh", got, queries.Load())
+	}
+	clock.Advance(80 * time.Second)
+	if got := resolve(); got != "192.0.2.1" {
+		t.Fatalf("Got: %s, Want: 192.0.2.1 still served from the cache while refreshing", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, ok := resolver.Cache.Get("www.example.com", A, IN)
+		if ok && response.Answers[0].Address == "192.0.2.2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Cache was not refreshed near expiry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := queries.Load(); got != 2 {
+		t.Fatalf("Got %d queries, Want: 2", got)
+	}
+}
@@ -0,0 +1,15 @@
+package dnsresolvr
+
+import "time"
+
+// Clock tells the current time. It lets TTL handling be tested without waiting on the wall
+// clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
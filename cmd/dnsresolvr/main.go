@@ -0,0 +1,129 @@
+// Command dnsresolvr resolves a domain name and prints the records it finds.
+//
+//	dnsresolvr [-type A] [-server host[:port]] [-short] name
+//	dnsresolvr [-short] -infile response.bin
+//
+// With -infile no query is sent, the response is read from the file instead.
+package main
+
+import (
+	"dnsresolvr"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes of the command.
+const (
+	exitAnswered = iota
+	exitFailed
+	exitUsage
+	exitNoData
+	exitNameError
+	exitServerFailure
+	exitRefused
+	exitOtherResponseCode
+)
+
+const exitCodeHelp = `exit codes:
+  0  the name resolved with answers
+  1  no response could be obtained
+  2  invalid usage
+  3  the name exists but has no records of the type (NOERROR without answers)
+  4  the name does not exist (NXDOMAIN)
+  5  the server failed (SERVFAIL)
+  6  the server refused the query (REFUSED)
+  7  any other response code`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command with args and returns its exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dnsresolvr", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "usage: dnsresolvr [flags] name\n       dnsresolvr [-short] -infile file")
+		flags.PrintDefaults()
+		_, _ = fmt.Fprintln(stderr, exitCodeHelp)
+	}
+	recordType := flags.String("type", "A", "record `type` to look up")
+	server := flags.String("server", "", "upstream `server` to query as host or host:port, names are resolved iteratively from the root when empty")
+	short := flags.Bool("short", false, "print only the data of the answers, one per line, like dig +short")
+	inFile := flags.String("infile", "", "parse the raw response in `file` instead of resolving a name")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return exitAnswered
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if *inFile != "" && flags.NArg() == 0 {
+		responseBytes, err := os.ReadFile(*inFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "dnsresolvr: %v\n", err)
+			return exitFailed
+		}
+		response, err := dnsresolvr.ParseResponse(responseBytes)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "dnsresolvr: %v\n", err)
+			return exitFailed
+		}
+		printAnswers(stdout, response, *short)
+		return exitCode(response)
+	}
+	if *inFile != "" || flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	qtype, err := dnsresolvr.ParseMessageType(*recordType)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "dnsresolvr: %v\n", err)
+		return exitUsage
+	}
+	resolver := &dnsresolvr.Resolver{}
+	if *server != "" {
+		resolver.Servers = []string{*server}
+	}
+	response, err := resolver.ResolveType(flags.Arg(0), qtype)
+	// Error response codes come with the response, they are reported by the exit code.
+	if response == nil {
+		_, _ = fmt.Fprintf(stderr, "dnsresolvr: %v\n", err)
+		return exitFailed
+	}
+	printAnswers(stdout, response, *short)
+	return exitCode(response)
+}
+
+// printAnswers prints the answers of response one per line, only their data when short is
+// set.
+func printAnswers(stdout io.Writer, response *dnsresolvr.DnsResponse, short bool) {
+	for _, answer := range response.Answers {
+		if short {
+			_, _ = fmt.Fprintln(stdout, answer.RdataPresentation())
+		} else {
+			_, _ = fmt.Fprintln(stdout, answer.Presentation())
+		}
+	}
+}
+
+// exitCode maps the response code of response to the exit code of the command.
+func exitCode(response *dnsresolvr.DnsResponse) int {
+	switch response.Header.ResponseCode {
+	case dnsresolvr.NoError:
+		if len(response.Answers) == 0 {
+			return exitNoData
+		}
+		return exitAnswered
+	case dnsresolvr.NameError:
+		return exitNameError
+	case dnsresolvr.ServerFailure:
+		return exitServerFailure
+	case dnsresolvr.Refused:
+		return exitRefused
+	default:
+		return exitOtherResponseCode
+	}
+}
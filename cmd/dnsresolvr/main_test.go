@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"dnsresolvr"
+	"net"
+	"testing"
+)
+
+// startServer answers every query over UDP with the response built by reply.
+func startServer(t *testing.T, reply func(query *dnsresolvr.DnsResponse)) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, remote, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query, err := dnsresolvr.ParseResponse(buffer[:n])
+			if err != nil {
+				continue
+			}
+			query.Header.IsResponse = true
+			query.AdditionalRecords = nil
+			reply(query)
+			responseBytes, err := query.GetBytes()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(responseBytes, remote)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func answerWithAddresses(addresses ...string) func(query *dnsresolvr.DnsResponse) {
+	return func(query *dnsresolvr.DnsResponse) {
+		for _, address := range addresses {
+			query.Answers = append(query.Answers, dnsresolvr.DnsAnswer{
+				Domain:      query.Question.GetDomainName(),
+				Address:     address,
+				RecordType:  dnsresolvr.A,
+				RecordClass: dnsresolvr.IN,
+				TTL:         300,
+			})
+		}
+	}
+}
+
+func TestRunPrintsRecords(t *testing.T) {
+	server := startServer(t, answerWithAddresses("93.184.216.34"))
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-server", server, "www.example.com"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Got exit code %d with %q, Want: 0", code, stderr.String())
+	}
+	if want := "www.example.com. 300 IN A 93.184.216.34\n"; stdout.String() != want {
+		t.Fatalf("Got: %q, Want: %q", stdout.String(), want)
+	}
+}
+
+func TestRunShort(t *testing.T) {
+	server := startServer(t, answerWithAddresses("93.184.216.34", "93.184.216.35"))
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-short", "-server", server, "www.example.com"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Got exit code %d with %q, Want: 0", code, stderr.String())
+	}
+	if want := "93.184.216.34\n93.184.216.35\n"; stdout.String() != want {
+		t.Fatalf("Got: %q, Want: %q", stdout.String(), want)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		rcode dnsresolvr.ResponseCode
+		want  int
+	}{
+		{dnsresolvr.NoError, exitNoData},
+		{dnsresolvr.NameError, exitNameError},
+		{dnsresolvr.ServerFailure, exitServerFailure},
+		{dnsresolvr.Refused, exitRefused},
+		{dnsresolvr.NotImplemented, exitOtherResponseCode},
+	}
+	for _, test := range tests {
+		// The server keeps answering with the handler after the iteration, which must not see
+		// the loop variable change under go 1.21 semantics.
+		test := test
+		server := startServer(t, func(query *dnsresolvr.DnsResponse) {
+			query.Header.ResponseCode = test.rcode
+		})
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-server", server, "www.example.com"}, &stdout, &stderr)
+		if code != test.want {
+			t.Fatalf("Got exit code %d for response code %d, Want: %d", code, test.rcode, test.want)
+		}
+	}
+}
+
+func TestRunUsageError(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run(nil, &stdout, &stderr); code != exitUsage {
+		t.Fatalf("Got exit code %d, Want: %d", code, exitUsage)
+	}
+	if code := run([]string{"-type", "BOGUS", "example.com"}, &stdout, &stderr); code != exitUsage {
+		t.Fatalf("Got exit code %d, Want: %d", code, exitUsage)
+	}
+}
+
+func TestRunInFile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-infile", "testdata/response.bin"}, &stdout, &stderr)
+	if code != exitAnswered {
+		t.Fatalf("Got exit code %d with %q, Want: %d", code, stderr.String(), exitAnswered)
+	}
+	want := "www.example.com. 300 IN A 93.184.216.34\nwww.example.com. 300 IN A 93.184.216.35\n"
+	if stdout.String() != want {
+		t.Fatalf("Got: %q, Want: %q", stdout.String(), want)
+	}
+}
+
+func TestRunInFileRejectsMalformedResponse(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-infile", "main.go"}, &stdout, &stderr)
+	if code != exitFailed {
+		t.Fatalf("Got exit code %d, Want: %d", code, exitFailed)
+	}
+}
@@ -0,0 +1,63 @@
+package dnsresolvr
+
+import "strings"
+
+// maxCompressionOffset is the largest offset a 14-bit compression pointer can hold.
+const maxCompressionOffset = 16383
+
+// compressionContext remembers where names have been written in a message so that later
+// occurrences can be replaced by pointers (RFC 1035 section 4.1.4). A nil context writes
+// names uncompressed.
+type compressionContext struct {
+	offsets map[string]int
+	// length is the number of bytes of the message written so far.
+	length int
+}
+
+func newCompressionContext(length int) *compressionContext {
+	return &compressionContext{offsets: make(map[string]int), length: length}
+}
+
+// appendName appends name to b, which must hold what is being written after the first
+// c.length bytes of the message, pointing to an earlier occurrence of any of its suffixes.
+// Names that splitDomainLabels rejects are not written.
+func (c *compressionContext) appendName(b []byte, name string) ([]byte, error) {
+	labels, err := splitDomainLabels(name)
+	if err != nil {
+		return b, err
+	}
+	for i := 0; i < len(labels); i++ {
+		if c != nil {
+			// Suffixes are matched case sensitively so that names keep their case.
+			suffix := strings.Join(labels[i:], ".")
+			if offset, ok := c.offsets[suffix]; ok {
+				return append(b, uint8(192|offset>>8), uint8(offset)), nil
+			}
+			if position := c.length + len(b); position <= maxCompressionOffset {
+				c.offsets[suffix] = position
+			}
+		}
+		b = append(b, uint8(len(labels[i])))
+		b = append(b, labels[i]...)
+	}
+	return append(b, 0), nil
+}
+
+// appendNames appends each of names to b in turn like appendName.
+func (c *compressionContext) appendNames(b []byte, names ...string) ([]byte, error) {
+	var err error
+	for _, name := range names {
+		b, err = c.appendName(b, name)
+		if err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// advance records that n more bytes have been written to the message.
+func (c *compressionContext) advance(n int) {
+	if c != nil {
+		c.length += n
+	}
+}
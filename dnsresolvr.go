@@ -1,18 +1,24 @@
 package dnsresolvr
 
 import (
+	"bytes"
+	"context"
 	"dnsresolvr/internal/pkg/bytereader"
 	"dnsresolvr/internal/pkg/utils"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
+	"math"
 	"net"
-	"os"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var rootNameServers = []string{
-	"192.41.0.4",
+	"198.41.0.4",
 	"170.247.170.2",
 	"192.33.4.12",
 	"199.7.91.13",
@@ -65,11 +71,57 @@ const (
 	MINFO
 	MX
 	TXT
+	RP
+	AFSDB
+	X25
+	ISDN
+	RT
+	AAAA   = 28
+	LOC    = 29
+	SRV    = 33
+	NAPTR  = 35
+	CERT   = 37
+	OPT    = 41
+	APL    = 42
+	DS     = 43
+	SSHFP  = 44
+	RRSIG  = 46
+	NSEC   = 47
+	DNSKEY = 48
+	TLSA   = 52
+	SVCB   = 64
+	HTTPS  = 65
+	SPF    = 99
+	EUI48  = 108
+	EUI64  = 109
+	// TKEY and TSIG are meta-records for establishing keys and authenticating transactions
+	// (RFC 2930, RFC 8945). Their record data is kept in RawData.
+	TKEY  = 249
+	TSIG  = 250
 	AXFR  = 252
 	MAILB = 253
 	MAILA = 254
+	// ANY_TYPE is the QTYPE "*" asking for records of all types. It shares its value with the
+	// ANY class but is a MessageType.
+	ANY_TYPE MessageType = 255
+	CAA                  = 257
 )
 
+// Matches reports whether records of type recordType answer a question of type t. Besides
+// records of the very type, the query-only type MAILB is answered by MB, MG and MR records,
+// MAILA by MD and MF records and ANY_TYPE by records of any type (RFC 1035 section 3.2.3).
+func (t MessageType) Matches(recordType MessageType) bool {
+	switch t {
+	case ANY_TYPE:
+		return true
+	case MAILB:
+		return recordType == MB || recordType == MG || recordType == MR
+	case MAILA:
+		return recordType == MD || recordType == MF
+	}
+	return t == recordType
+}
+
 type MessageClass uint16
 
 const (
@@ -80,6 +132,15 @@ const (
 	ANY = 255
 )
 
+// Section identifies the section of a message a record was read from.
+type Section uint8
+
+const (
+	AnswerSection Section = iota
+	AuthoritySection
+	AdditionalSection
+)
+
 type DnsHeader struct {
 	Id                          uint16
 	IsResponse                  bool
@@ -88,11 +149,14 @@ type DnsHeader struct {
 	IsTruncatedMessage          bool
 	IsRecursionDesired          bool
 	IsRecursionSupportAvailable bool
-	ResponseCode                ResponseCode
-	QuestionCount               uint16
-	AnswerCount                 uint16
-	NameServerRecordsCount      uint16
-	AdditionalRecordsCount      uint16
+	// IsAuthenticData is the AD bit: the server vouches that it validated all answer and
+	// authority records with DNSSEC (RFC 4035 section 3.2.3).
+	IsAuthenticData        bool
+	ResponseCode           ResponseCode
+	QuestionCount          uint16
+	AnswerCount            uint16
+	NameServerRecordsCount uint16
+	AdditionalRecordsCount uint16
 }
 
 func (h DnsHeader) GetBytes() []byte {
@@ -112,7 +176,7 @@ func (h DnsHeader) getHeaderMetadata() []byte {
 	if h.IsResponse {
 		headerMeta += 1 << 15
 	}
-	headerMeta += uint16(h.Opcode) << 14
+	headerMeta += uint16(h.Opcode) << 11
 	if h.IsAuthoritativeAnswer {
 		headerMeta += 1 << 10
 	}
@@ -125,6 +189,9 @@ func (h DnsHeader) getHeaderMetadata() []byte {
 	if h.IsRecursionSupportAvailable {
 		headerMeta += 1 << 7
 	}
+	if h.IsAuthenticData {
+		headerMeta += 1 << 5
+	}
 	headerMeta += uint16(h.ResponseCode)
 	return utils.ConvertUint16ToBytesArray(headerMeta)
 }
@@ -146,36 +213,617 @@ func (q DnsQueryQuestion) GetBytes() []byte {
 type DnsQuery struct {
 	Header    DnsHeader
 	Questions []DnsQueryQuestion
+	// Edns, when set, is sent as an OPT record in the additional section.
+	Edns *Edns
+}
+
+// HexDump returns the query in wire format as a hex dump with offsets and the printable
+// bytes, like hexdump -C.
+func (q DnsQuery) HexDump() string {
+	return hex.Dump(q.GetBytes())
+}
+
+// Validate checks that the query asks each question only once, names being compared without
+// regard to case.
+func (q DnsQuery) Validate() error {
+	seen := make(map[cacheKey]bool, len(q.Questions))
+	for _, question := range q.Questions {
+		if len(question.Qname) == 0 {
+			return errors.New("invalid question name")
+		}
+		key := newCacheKey(question.GetDomainName(), question.Qtype, question.Qclass)
+		if seen[key] {
+			return fmt.Errorf("duplicate question %s %s %s", question.GetDomainName(), question.Qclass, question.Qtype)
+		}
+		seen[key] = true
+	}
+	return nil
 }
 
 func (q DnsQuery) GetBytes() []byte {
 	var queryBytes []byte
-	queryBytes = append(queryBytes, q.Header.GetBytes()...)
+	header := q.Header
+	if q.Edns != nil {
+		header.AdditionalRecordsCount = 1
+	}
+	queryBytes = append(queryBytes, header.GetBytes()...)
 	for i := 0; i < len(q.Questions); i++ {
 		queryQuestion := q.Questions[i]
 		queryBytes = append(queryBytes, queryQuestion.GetBytes()...)
 	}
+	if q.Edns != nil {
+		queryBytes = append(queryBytes, q.Edns.GetBytes()...)
+	}
 	return queryBytes
 }
 
 type DnsAnswer struct {
-	Domain      string
-	Address     string
+	Domain string
+	// Address holds the address of A and AAAA records and the identifier of EUI48 and EUI64
+	// records, written as colon separated hex like "00:00:5e:00:53:2a".
+	Address string
+	Target  string
+	Soa     *SoaData
+	Minfo   *MinfoData
+	Rp      *RpData
+	Afsdb   *AfsdbData
+	Rt      *RtData
+	Mx      *MxData
+	Srv     *SrvData
+	// Txt holds the character strings of TXT and SPF records, the PSDN address of X25 records
+	// and the address and optional subaddress of ISDN records.
+	Txt         []string
+	Hinfo       *HinfoData
+	Caa         *CaaData
+	Apl         *AplData
+	Nsec        *NsecData
+	Rrsig       *RrsigData
+	Dnskey      *DnskeyData
+	Ds          *DsData
+	Cert        *CertData
+	Sshfp       *SshfpData
+	EdnsOptions []EdnsOption
+	Loc         *LocData
+	Naptr       *NaptrData
+	Tlsa        *TlsaData
+	Svcb        *SvcbData
+	RawData     []byte
 	RecordType  MessageType
 	RecordClass MessageClass
 	TTL         uint32
+	Section     Section
+	// ExpiresAt is the time the record was parsed plus its TTL.
+	ExpiresAt time.Time
+}
+
+// SoaData holds the fields of the SOA record marking the start of a zone (RFC 1035 section
+// 3.3.13). Minimum is also the TTL of negative answers from the zone (RFC 2308).
+type SoaData struct {
+	Mname   string
+	Rname   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// MinfoData holds the mailbox responsible for a mailing list and the mailbox that receives
+// errors for it.
+type MinfoData struct {
+	Rmailbx string
+	Emailbx string
+}
+
+// RpData holds the mailbox of the person responsible for a name and the name of TXT records
+// with more information about them (RFC 1183 section 2.2). Either is the root when unknown.
+type RpData struct {
+	Mbox string
+	Txt  string
+}
+
+// AfsdbData holds the subtype and the host of an AFS database server record (RFC 1183
+// section 1): subtype 1 is an AFS volume location server, 2 a DCE authenticated name server.
+type AfsdbData struct {
+	Subtype  uint16
+	Hostname string
+}
+
+// RtData holds the preference and the intermediate host of a route through record (RFC 1183
+// section 3.3).
+type RtData struct {
+	Preference       uint16
+	IntermediateHost string
+}
+
+// MxData holds the preference and the host of a mail exchange record.
+type MxData struct {
+	Preference uint16
+	Exchange   string
+}
+
+// HinfoData describes the CPU and operating system of a host.
+type HinfoData struct {
+	Cpu string
+	Os  string
+}
+
+// CaaData holds a certification authority authorization property (RFC 8659).
+type CaaData struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// AplData holds the address prefix list of an APL record (RFC 3123).
+type AplData struct {
+	Prefixes []AplPrefix
+}
+
+// AplPrefix is a single entry of an address prefix list. AfdPart is the address with its
+// trailing zero bytes left out, AfdLength bytes long.
+type AplPrefix struct {
+	Family       uint16
+	PrefixLength uint8
+	Negation     bool
+	AfdLength    uint8
+	AfdPart      []byte
+}
+
+// NsecData holds the next owner name of an NSEC record and the types present at its owner.
+type NsecData struct {
+	NextDomain string
+	Types      []MessageType
+}
+
+// RrsigData holds the fields of an RRSIG record. The signature is kept as raw bytes.
+type RrsigData struct {
+	TypeCovered MessageType
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// DnskeyData holds the fields of a DNSKEY record. The public key is kept as raw bytes.
+type DnskeyData struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// DsData holds the fields of a DS record.
+type DsData struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// CertData holds the fields of a CERT record (RFC 4398). The certificate or CRL is kept as
+// raw bytes.
+type CertData struct {
+	Type        uint16
+	KeyTag      uint16
+	Algorithm   uint8
+	Certificate []byte
+}
+
+// SshfpData holds the fields of an SSHFP record (RFC 4255). The fingerprint of the host key is
+// written in lowercase hex.
+type SshfpData struct {
+	Algorithm       uint8
+	FingerprintType uint8
+	Fingerprint     string
+}
+
+// LocData holds the location of a LOC record (RFC 1876). Latitude and longitude are in
+// degrees, positive to the north and east, and the altitude, size and precisions in meters.
+type LocData struct {
+	Version             uint8
+	Size                float64
+	HorizontalPrecision float64
+	VerticalPrecision   float64
+	Latitude            float64
+	Longitude           float64
+	Altitude            float64
+}
+
+// SrvData holds the fields of an SRV record (RFC 2782): the host and port a service is
+// offered at. Hosts with lower priority are tried first, ones with equal priority in
+// proportion to their weight.
+type SrvData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// NaptrData holds the fields of a NAPTR record (RFC 3403).
+type NaptrData struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// TlsaData holds the fields of a TLSA record (RFC 6698). The certificate association data is
+// kept as raw bytes.
+type TlsaData struct {
+	Usage                      uint8
+	Selector                   uint8
+	MatchingType               uint8
+	CertificateAssociationData []byte
+}
+
+// SvcbData holds the fields of an SVCB or HTTPS record (RFC 9460). Params are kept in the
+// order they appear in the record.
+type SvcbData struct {
+	Priority uint16
+	Target   string
+	Params   []SvcParam
+}
+
+// SvcParam is a single service parameter of an SVCB or HTTPS record, its value in wire
+// format.
+type SvcParam struct {
+	Key   uint16
+	Value []byte
+}
+
+// GetBytes serializes the record in wire format. Names are compressed against the names
+// already written to the message when ctx is not nil.
+func (a DnsAnswer) GetBytes(ctx *compressionContext) ([]byte, error) {
+	var address net.IP
+	switch a.RecordType {
+	case A:
+		address = net.ParseIP(a.Address).To4()
+	case AAAA:
+		ip, err := netip.ParseAddr(a.Address)
+		if err == nil && ip.Is6() && ip.Zone() == "" {
+			address = ip.AsSlice()
+		}
+	case EUI48, EUI64:
+		identifier, err := net.ParseMAC(a.Address)
+		if err == nil && len(identifier) == euiLength(a.RecordType) {
+			address = net.IP(identifier)
+		}
+	}
+	if (a.RecordType == A || a.RecordType == AAAA || a.RecordType == EUI48 || a.RecordType == EUI64) && address == nil {
+		return nil, fmt.Errorf("invalid address %q for record type %d", a.Address, a.RecordType)
+	}
+	if !a.hasRecordData() {
+		return nil, fmt.Errorf("missing data for record type %d", a.RecordType)
+	}
+	err := checkCharacterStringCount(a.RecordType, len(a.Txt))
+	if err != nil {
+		return nil, err
+	}
+	characterStrings := a.Txt
+	if a.RecordType == HINFO {
+		characterStrings = []string{a.Hinfo.Cpu, a.Hinfo.Os}
+	} else if a.RecordType == CAA {
+		characterStrings = []string{a.Caa.Tag}
+	} else if a.RecordType == NAPTR {
+		characterStrings = []string{a.Naptr.Flags, a.Naptr.Service, a.Naptr.Regexp}
+	}
+	for _, characterString := range characterStrings {
+		if len(characterString) > 255 {
+			return nil, errors.New("character string longer than 255 bytes")
+		}
+	}
+	answerBytes, err := ctx.appendName(nil, a.Domain)
+	if err != nil {
+		return nil, err
+	}
+	answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(uint16(a.RecordType))...)
+	answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(uint16(a.RecordClass))...)
+	answerBytes = append(answerBytes, utils.ConvertUint32ToBytesArray(a.TTL)...)
+	dataLengthPosition := len(answerBytes)
+	answerBytes = append(answerBytes, 0, 0)
+	switch a.RecordType {
+	case A, AAAA, EUI48, EUI64:
+		answerBytes = append(answerBytes, address...)
+	case NS, MD, MF, CNAME, MB, MG, MR, PTR:
+		answerBytes, err = ctx.appendName(answerBytes, a.Target)
+	case SOA:
+		answerBytes, err = ctx.appendNames(answerBytes, a.Soa.Mname, a.Soa.Rname)
+		for _, field := range []uint32{a.Soa.Serial, a.Soa.Refresh, a.Soa.Retry, a.Soa.Expire, a.Soa.Minimum} {
+			answerBytes = append(answerBytes, utils.ConvertUint32ToBytesArray(field)...)
+		}
+	case MINFO:
+		answerBytes, err = ctx.appendNames(answerBytes, a.Minfo.Rmailbx, a.Minfo.Emailbx)
+	case RP:
+		// Names in record types defined after RFC 1035 must not be compressed (RFC 3597).
+		var uncompressed *compressionContext
+		answerBytes, err = uncompressed.appendNames(answerBytes, a.Rp.Mbox, a.Rp.Txt)
+	case AFSDB:
+		var uncompressed *compressionContext
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Afsdb.Subtype)...)
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Afsdb.Hostname)
+	case RT:
+		var uncompressed *compressionContext
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Rt.Preference)...)
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Rt.IntermediateHost)
+	case MX:
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Mx.Preference)...)
+		answerBytes, err = ctx.appendName(answerBytes, a.Mx.Exchange)
+	case SRV:
+		var uncompressed *compressionContext
+		for _, field := range []uint16{a.Srv.Priority, a.Srv.Weight, a.Srv.Port} {
+			answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(field)...)
+		}
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Srv.Target)
+	case TXT, SPF, HINFO, X25, ISDN:
+		for _, characterString := range characterStrings {
+			answerBytes = append(answerBytes, uint8(len(characterString)))
+			answerBytes = append(answerBytes, characterString...)
+		}
+	case CAA:
+		answerBytes = append(answerBytes, a.Caa.Flags, uint8(len(a.Caa.Tag)))
+		answerBytes = append(answerBytes, a.Caa.Tag...)
+		answerBytes = append(answerBytes, a.Caa.Value...)
+	case APL:
+		answerBytes, err = appendApl(answerBytes, a.Apl)
+	case NSEC:
+		var uncompressed *compressionContext
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Nsec.NextDomain)
+		answerBytes = appendTypeBitmap(answerBytes, a.Nsec.Types)
+	case RRSIG:
+		var uncompressed *compressionContext
+		rrsig := a.Rrsig
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(uint16(rrsig.TypeCovered))...)
+		answerBytes = append(answerBytes, rrsig.Algorithm, rrsig.Labels)
+		for _, field := range []uint32{rrsig.OriginalTTL, rrsig.Expiration, rrsig.Inception} {
+			answerBytes = append(answerBytes, utils.ConvertUint32ToBytesArray(field)...)
+		}
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(rrsig.KeyTag)...)
+		answerBytes, err = uncompressed.appendName(answerBytes, rrsig.SignerName)
+		answerBytes = append(answerBytes, rrsig.Signature...)
+	case DNSKEY:
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Dnskey.Flags)...)
+		answerBytes = append(answerBytes, a.Dnskey.Protocol, a.Dnskey.Algorithm)
+		answerBytes = append(answerBytes, a.Dnskey.PublicKey...)
+	case DS:
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Ds.KeyTag)...)
+		answerBytes = append(answerBytes, a.Ds.Algorithm, a.Ds.DigestType)
+		answerBytes = append(answerBytes, a.Ds.Digest...)
+	case LOC:
+		answerBytes, err = appendLoc(answerBytes, a.Loc)
+	case NAPTR:
+		var uncompressed *compressionContext
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Naptr.Order)...)
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Naptr.Preference)...)
+		for _, characterString := range characterStrings {
+			answerBytes = append(answerBytes, uint8(len(characterString)))
+			answerBytes = append(answerBytes, characterString...)
+		}
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Naptr.Replacement)
+	case TLSA:
+		answerBytes = append(answerBytes, a.Tlsa.Usage, a.Tlsa.Selector, a.Tlsa.MatchingType)
+		answerBytes = append(answerBytes, a.Tlsa.CertificateAssociationData...)
+	case SVCB, HTTPS:
+		var uncompressed *compressionContext
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Svcb.Priority)...)
+		answerBytes, err = uncompressed.appendName(answerBytes, a.Svcb.Target)
+		for _, param := range a.Svcb.Params {
+			answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(param.Key)...)
+			answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(uint16(len(param.Value)))...)
+			answerBytes = append(answerBytes, param.Value...)
+		}
+	case CERT:
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Cert.Type)...)
+		answerBytes = append(answerBytes, utils.ConvertUint16ToBytesArray(a.Cert.KeyTag)...)
+		answerBytes = append(answerBytes, a.Cert.Algorithm)
+		answerBytes = append(answerBytes, a.Cert.Certificate...)
+	case SSHFP:
+		var fingerprint []byte
+		fingerprint, err = hex.DecodeString(a.Sshfp.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSHFP fingerprint %q: %w", a.Sshfp.Fingerprint, err)
+		}
+		answerBytes = append(answerBytes, a.Sshfp.Algorithm, a.Sshfp.FingerprintType)
+		answerBytes = append(answerBytes, fingerprint...)
+	case OPT:
+		for _, option := range a.EdnsOptions {
+			answerBytes = append(answerBytes, option.getBytes()...)
+		}
+	default:
+		answerBytes = append(answerBytes, a.RawData...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataLength := len(answerBytes) - dataLengthPosition - 2
+	if dataLength > 65535 {
+		return nil, errors.New("record data longer than 65535 bytes")
+	}
+	copy(answerBytes[dataLengthPosition:], utils.ConvertUint16ToBytesArray(uint16(dataLength)))
+	ctx.advance(len(answerBytes))
+	return answerBytes, nil
+}
+
+// IP returns the address of an A or AAAA record, or nil for other record types.
+func (a DnsAnswer) IP() net.IP {
+	if a.RecordType != A && a.RecordType != AAAA {
+		return nil
+	}
+	return net.ParseIP(a.Address)
 }
 
 type DnsResponse struct {
-	Header   *DnsHeader
+	Header *DnsHeader
+	// Question is the first of the Questions, which is the only one in practice.
 	Question *DnsQueryQuestion
-	Answers  []DnsAnswer
+	// Questions are all the questions of a parsed message. When empty, GetBytes writes
+	// Question alone.
+	Questions         []DnsQueryQuestion
+	Answers           []DnsAnswer
+	NameServerRecords []DnsAnswer
+	AdditionalRecords []DnsAnswer
+	// Server is the address of the name server the response came from. It is only set by
+	// the Resolver, for iterative resolution it is the authoritative server that answered.
+	Server string
+	// Validated reports that the records of the response were validated with DNSSEC, which
+	// NewForwardedResponse signals to clients with the AD bit.
+	Validated bool
+
+	// raw holds the message the response was parsed from.
+	raw []byte
+}
+
+// HexDump returns the response in wire format as a hex dump with offsets and the printable
+// bytes, like hexdump -C. A parsed response is dumped exactly as it was received, otherwise it
+// is serialized first. It returns an empty string when that fails.
+func (r DnsResponse) HexDump() string {
+	responseBytes := r.raw
+	if responseBytes == nil {
+		var err error
+		responseBytes, err = r.GetBytes()
+		if err != nil {
+			return ""
+		}
+	}
+	return hex.Dump(responseBytes)
+}
+
+// GetBytes serializes the response in wire format, compressing names. The section counts in
+// the header are taken from the record slices.
+func (r DnsResponse) GetBytes() ([]byte, error) {
+	header := *r.Header
+	questions := r.Questions
+	if len(questions) == 0 && r.Question != nil {
+		questions = []DnsQueryQuestion{*r.Question}
+	}
+	header.QuestionCount = uint16(len(questions))
+	header.AnswerCount = uint16(len(r.Answers))
+	header.NameServerRecordsCount = uint16(len(r.NameServerRecords))
+	header.AdditionalRecordsCount = uint16(len(r.AdditionalRecords))
+	responseBytes := header.GetBytes()
+	ctx := newCompressionContext(len(responseBytes))
+	for _, question := range questions {
+		questionBytes, err := ctx.appendName(nil, question.GetDomainName())
+		if err != nil {
+			return nil, err
+		}
+		questionBytes = append(questionBytes, utils.ConvertUint16ToBytesArray(uint16(question.Qtype))...)
+		questionBytes = append(questionBytes, utils.ConvertUint16ToBytesArray(uint16(question.Qclass))...)
+		ctx.advance(len(questionBytes))
+		responseBytes = append(responseBytes, questionBytes...)
+	}
+	for _, section := range [][]DnsAnswer{r.Answers, r.NameServerRecords, r.AdditionalRecords} {
+		for _, record := range section {
+			recordBytes, err := record.GetBytes(ctx)
+			if err != nil {
+				return nil, err
+			}
+			responseBytes = append(responseBytes, recordBytes...)
+		}
+	}
+	return responseBytes, nil
+}
+
+// mergeResponses combines responses into one with the answers of all of them, in order. The
+// header, question and other sections are those of the first response. It returns nil when
+// there is no response.
+func mergeResponses(responses ...*DnsResponse) *DnsResponse {
+	if len(responses) == 0 {
+		return nil
+	}
+	merged := *responses[0]
+	header := *merged.Header
+	merged.Header = &header
+	merged.raw = nil
+	merged.Answers = nil
+	for _, response := range responses {
+		merged.Answers = append(merged.Answers, response.Answers...)
+	}
+	header.AnswerCount = uint16(len(merged.Answers))
+	return &merged
+}
+
+// NewForwardedResponse builds the response a forwarding server sends back for query from the
+// response it got upstream. It echoes the question of query, which may be written differently
+// from the upstream one, so the records are serialized anew rather than copied. The AD bit the
+// upstream server set is not passed on as is: it is set only when the response was Validated
+// and cleared otherwise (RFC 4035 section 3.2.3).
+func NewForwardedResponse(query *DnsQuery, upstream *DnsResponse) *DnsResponse {
+	header := *upstream.Header
+	header.Id = query.Header.Id
+	header.IsRecursionDesired = query.Header.IsRecursionDesired
+	header.IsAuthenticData = upstream.Validated
+	forwarded := *upstream
+	forwarded.Header = &header
+	forwarded.Questions = slices.Clone(query.Questions)
+	forwarded.Question = nil
+	if len(forwarded.Questions) > 0 {
+		forwarded.Question = &forwarded.Questions[0]
+	}
+	forwarded.raw = nil
+	return &forwarded
+}
+
+// buildErrorResponse builds a response to query that carries no records and reports rcode,
+// e.g. NameError for NXDOMAIN.
+func buildErrorResponse(query *DnsQuery, rcode ResponseCode) *DnsResponse {
+	responseHeader := &DnsHeader{}
+	responseHeader.Id = query.Header.Id
+	responseHeader.IsResponse = true
+	responseHeader.Opcode = query.Header.Opcode
+	responseHeader.IsRecursionDesired = query.Header.IsRecursionDesired
+	responseHeader.ResponseCode = rcode
+	response := &DnsResponse{}
+	response.Header = responseHeader
+	if len(query.Questions) != 0 {
+		question := query.Questions[0]
+		response.Question = &question
+		responseHeader.QuestionCount = 1
+	}
+	return response
+}
+
+// splitDomainLabels splits name into its labels, ignoring a single trailing dot. The root,
+// written "" or ".", has no labels. Empty labels, labels longer than 63 bytes, names of more
+// than 127 labels and names too long for the wire format are rejected.
+func splitDomainLabels(name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil, nil
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) > bytereader.MaxLabels {
+		return nil, fmt.Errorf("domain name with %d labels, more than %d", len(labels), bytereader.MaxLabels)
+	}
+	length := 1
+	for _, label := range labels {
+		if len(label) == 0 {
+			return nil, fmt.Errorf("empty label in domain name %q", name)
+		}
+		if len(label) > 63 {
+			return nil, fmt.Errorf("label %q of domain name %q longer than 63 bytes", label, name)
+		}
+		length += 1 + len(label)
+	}
+	if length > bytereader.MaxNameLength {
+		return nil, fmt.Errorf("domain name %q longer than %d bytes", name, bytereader.MaxNameLength)
+	}
+	return labels, nil
 }
 
 // Converts domain name string to qname format. e.g "www.google.com" gets converted to
-// "3www6google3com0" in bytes
+// "3www6google3com0" in bytes. Returns nil when splitDomainLabels rejects the name.
 func getDomainNameInQnameFormat(domainName string) []byte {
-	nameParts := strings.Split(domainName, ".")
+	nameParts, err := splitDomainLabels(domainName)
+	if err != nil {
+		return nil
+	}
 	var QnameBytes []byte
 	for i := 0; i < len(nameParts); i++ {
 		namePart := nameParts[i]
@@ -186,82 +834,346 @@ func getDomainNameInQnameFormat(domainName string) []byte {
 	return QnameBytes
 }
 
-func generateDnsQuery(domainName string) *DnsQuery {
+// IsValidHostname reports whether s is a valid host name per RFC 1123: at most 253
+// characters, made of 1 to 63 character labels of letters, digits and hyphens that don't
+// start or end with a hyphen. A single trailing dot is allowed.
+func IsValidHostname(s string) bool {
+	labels, err := splitDomainLabels(s)
+	if err != nil || len(labels) == 0 {
+		return false
+	}
+	for _, label := range labels {
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GetDomainName converts the qname of the question back to a dotted domain name.
+func (q DnsQueryQuestion) GetDomainName() string {
+	return readDomainFromResponse(bytereader.NewByteReader(q.Qname))
+}
+
+func generateDnsQuery(domainName string, qtype MessageType) *DnsQuery {
+	return NewQueryFromQuestion(DnsQueryQuestion{
+		Qname:  getDomainNameInQnameFormat(domainName),
+		Qtype:  qtype,
+		Qclass: IN,
+	})
+}
+
+// NewQueryFromQuestion builds a standard query asking q under a new random ID, for example to
+// ask the question of a response again.
+func NewQueryFromQuestion(q DnsQueryQuestion) *DnsQuery {
 	queryHeader := &DnsHeader{}
 	queryHeader.Id = utils.GetRandomUint16()
 	queryHeader.Opcode = StandardQuery
 	queryHeader.QuestionCount = 1
 	queryHeader.IsRecursionDesired = false
-	queryQuestion := &DnsQueryQuestion{}
-	queryQuestion.Qname = getDomainNameInQnameFormat(domainName)
-	queryQuestion.Qclass = IN
-	queryQuestion.Qtype = A
+	q.Qname = append([]byte(nil), q.Qname...)
 	query := &DnsQuery{}
 	query.Header = *queryHeader
-	query.Questions = []DnsQueryQuestion{*queryQuestion}
+	query.Questions = []DnsQueryQuestion{q}
 	return query
 }
 
-func queryDns(domainName string) ([]byte, error) {
-	dnsQuery := generateDnsQuery(domainName)
-	addr, err := net.ResolveUDPAddr("udp", "198.41.0.4:53")
+// queryDns sends the query to server over UDP and returns the raw response, read into a
+// buffer of bufferSize bytes. The exchange is abandoned after timeout or once ctx is done,
+// whichever comes first.
+func queryDns(ctx context.Context, dnsQuery *DnsQuery, server string, timeout time.Duration, bufferSize int) ([]byte, error) {
+	return queryDnsWithDialer(ctx, dialUDP, dnsQuery, server, timeout, bufferSize)
+}
+
+// dialUDP connects to server over UDP, picking IPv4 or IPv6 from its address.
+func dialUDP(_ context.Context, _ string, server string) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", server)
 	if err != nil {
-		fmt.Println("Error occurred while resolving address for DNS. ", err)
-		os.Exit(2)
+		return nil, err
+	}
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
 	}
-	udp, err := net.DialUDP("udp", nil, addr)
+	return net.DialUDP(network, nil, addr)
+}
+
+// queryDnsWithDialer is queryDns over a connection made by dial, which is called with the
+// "udp" network and server.
+func queryDnsWithDialer(ctx context.Context, dial func(ctx context.Context, network, address string) (net.Conn, error),
+	dnsQuery *DnsQuery, server string, timeout time.Duration, bufferSize int) ([]byte, error) {
+	udp, err := dial(ctx, "udp", server)
 	if err != nil {
-		fmt.Println("Error occurred while initiating connection with DNS. ", err)
-		os.Exit(2)
+		return nil, err
 	}
-	defer func(udp *net.UDPConn) {
+	defer func(udp net.Conn) {
 		_ = udp.Close()
 	}(udp)
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = udp.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = udp.SetDeadline(time.Now())
+	})
+	defer stop()
 	_, connErr := udp.Write(dnsQuery.GetBytes())
 	if connErr != nil {
-		fmt.Println("Error sending request to DNS.", connErr)
-		os.Exit(2)
+		return nil, connErr
+	}
+	// Connections made by net.DialUDP only receive datagrams from the server, but a dialer
+	// may hand out a socket that receives from anyone, so the source is checked too.
+	packetConn, _ := udp.(net.PacketConn)
+	response := make([]byte, bufferSize)
+	for {
+		var responseLength int
+		var source net.Addr
+		var readErr error
+		if packetConn != nil {
+			responseLength, source, readErr = packetConn.ReadFrom(response)
+		} else {
+			responseLength, readErr = udp.Read(response)
+		}
+		if readErr != nil {
+			return nil, contextError(ctx, readErr)
+		}
+		// A spoofed datagram may arrive before the real response, so anything that doesn't
+		// answer our query is dropped and we keep waiting until the deadline.
+		if source != nil && udp.RemoteAddr() != nil && !sameUDPAddress(source, udp.RemoteAddr()) {
+			continue
+		}
+		if !isResponseToQuery(dnsQuery, response[:responseLength]) {
+			continue
+		}
+		udpResponse := make([]byte, responseLength)
+		copy(udpResponse, response)
+		return udpResponse, nil
+	}
+}
+
+// sameUDPAddress reports whether a and b are the same IP address and port, taking IPv4
+// addresses and their IPv4-mapped IPv6 form as the same.
+func sameUDPAddress(a, b net.Addr) bool {
+	udpA, okA := a.(*net.UDPAddr)
+	udpB, okB := b.(*net.UDPAddr)
+	if !okA || !okB {
+		return a.String() == b.String()
+	}
+	addrPortA, addrPortB := udpA.AddrPort(), udpB.AddrPort()
+	return addrPortA.Addr().Unmap() == addrPortB.Addr().Unmap() && addrPortA.Port() == addrPortB.Port()
+}
+
+// isResponseToQuery reports whether the raw response carries the ID and the question of the
+// query. Names are compared case-insensitively since servers need not preserve case. FORMERR
+// and NOTIMP responses, which servers that couldn't make sense of the query may send without
+// any question, only need the ID. Other responses must carry the question, since an error
+// such as NXDOMAIN accepted on the ID alone is easily spoofed.
+func isResponseToQuery(dnsQuery *DnsQuery, response []byte) bool {
+	if len(response) < 12 {
+		return false
+	}
+	if utils.GetUint16FromBytes(response[:2]) != dnsQuery.Header.Id || response[2]&128 == 0 {
+		return false
+	}
+	if responseCode := ResponseCode(response[3] & 15); utils.GetUint16FromBytes(response[4:6]) == 0 &&
+		(responseCode == FormatError || responseCode == NotImplemented) {
+		return true
+	}
+	var questionBytes []byte
+	for _, question := range dnsQuery.Questions {
+		questionBytes = append(questionBytes, question.GetBytes()...)
+	}
+	if len(response) < 12+len(questionBytes) {
+		return false
+	}
+	return bytes.EqualFold(response[12:12+len(questionBytes)], questionBytes)
+}
+
+// obsoleteMessageTypes are record types that have been declared obsolete or were only ever
+// experimental. They are rejected when parsing strictly.
+var obsoleteMessageTypes = []MessageType{MD, MF, MB, MG, MR, MINFO, NULL}
+
+// minRecordLength is the size of the smallest possible resource record: a root owner name
+// followed by type, class, TTL and an empty rdata.
+const minRecordLength = 11
+
+type parseOptions struct {
+	// strict rejects obsolete opcodes and record types instead of parsing them, as well as
+	// records whose data is longer than their type calls for.
+	strict bool
+	// now returns the time records are considered parsed at. Defaults to time.Now.
+	now func() time.Time
+	// maxRecords is the number of records kept from each section, the others are read and
+	// dropped. Zero keeps them all.
+	maxRecords int
+	// onRecord, when set, is passed every record as soon as it is parsed instead of the
+	// record being kept in the response. An error from it stops parsing.
+	onRecord func(DnsAnswer) error
+}
+
+// ParseResponse parses a DNS message in wire format. Malformed input is reported as an
+// error wrapping ErrMalformedResponse.
+func ParseResponse(response []byte) (*DnsResponse, error) {
+	return parseResponseWithOptions(response, parseOptions{})
+}
+
+// ParseResponseStream parses a DNS message in wire format like ParseResponse, but hands each
+// record of the answer, authority and additional sections to onRecord in turn rather than
+// collecting them, and returns only the header. Returning an error from onRecord stops
+// parsing and that error is returned as is.
+func ParseResponseStream(response []byte, onRecord func(DnsAnswer) error) (*DnsHeader, error) {
+	var stopErr error
+	dnsResponse, err := parseMessage(response, parseOptions{onRecord: func(record DnsAnswer) error {
+		stopErr = onRecord(record)
+		return stopErr
+	}})
+	if stopErr != nil {
+		return nil, stopErr
 	}
-	response := make([]byte, 512)
-	responseLength, readErr := udp.Read(response)
-	if readErr != nil {
-		return nil, readErr
+	if err != nil {
+		return nil, wrapError(ErrMalformedResponse, err)
+	}
+	return dnsResponse.Header, nil
+}
+
+func parseResponseWithOptions(response []byte, options parseOptions) (*DnsResponse, error) {
+	dnsResponse, err := parseMessage(response, options)
+	if err != nil {
+		return nil, wrapError(ErrMalformedResponse, err)
 	}
-	udpResponse := make([]byte, responseLength)
-	copy(udpResponse, response)
-	return udpResponse, nil
+	return dnsResponse, nil
 }
 
-func parseResponse(response []byte) {
+func parseMessage(response []byte, options parseOptions) (*DnsResponse, error) {
 	responseReader := bytereader.NewByteReader(response)
-	dnsResponse := &DnsResponse{}
+	dnsResponse := &DnsResponse{raw: append([]byte(nil), response...)}
 	dnsHeader := &DnsHeader{}
-	var dnsAnswers []DnsAnswer
 	dnsResponse.Header = dnsHeader
-	dnsResponse.Answers = dnsAnswers
 	responseId, err := responseReader.ReadUint16()
 	if err != nil {
-		fmt.Println("Error parsing response ID: ", err)
+		return nil, err
 	}
 	dnsHeader.Id = responseId
-	headerMeta, _ := responseReader.ReadUint16()
+	headerMeta, err := responseReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
 	_ = populateDnsHeaderWithMetadata(headerMeta, dnsHeader)
-	dnsHeader.QuestionCount, _ = responseReader.ReadUint16()
-	dnsHeader.AnswerCount, _ = responseReader.ReadUint16()
-	fmt.Println("Answer count: ", dnsHeader.AnswerCount)
-	dnsHeader.NameServerRecordsCount, _ = responseReader.ReadUint16()
-	fmt.Println("Name Server Records: ", dnsHeader.NameServerRecordsCount)
-	dnsHeader.AdditionalRecordsCount, _ = responseReader.ReadUint16()
-	_ = readDomainFromResponse(responseReader)
-	_, _ = responseReader.ReadUint16()
-	_, _ = responseReader.ReadUint16()
-	for i := 0; uint16(i) < dnsHeader.AnswerCount; i++ {
-		ans := parseAnswersFromResponse(responseReader)
-		dnsResponse.Answers = append(dnsResponse.Answers, *ans)
+	if options.strict && dnsHeader.Opcode == InverseQuery {
+		return nil, errors.New("obsolete opcode IQUERY in response")
+	}
+	counts := []*uint16{
+		&dnsHeader.QuestionCount,
+		&dnsHeader.AnswerCount,
+		&dnsHeader.NameServerRecordsCount,
+		&dnsHeader.AdditionalRecordsCount,
 	}
-	for j := 0; uint16(j) < dnsHeader.NameServerRecordsCount; j++ {
-		parseAnswersFromResponse(responseReader)
+	for _, count := range counts {
+		*count, err = responseReader.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
 	}
+	// Servers echo the question in every answer to a standard query, but errors such as
+	// FORMERR may come without it, in which case Question stays nil.
+	if dnsHeader.IsResponse && dnsHeader.Opcode == StandardQuery && dnsHeader.ResponseCode == NoError && dnsHeader.QuestionCount == 0 {
+		return nil, errors.New("standard query response without question")
+	}
+	for i := 0; uint16(i) < dnsHeader.QuestionCount; i++ {
+		question, err := parseQuestionFromResponse(responseReader)
+		if err != nil {
+			return nil, err
+		}
+		dnsResponse.Questions = append(dnsResponse.Questions, *question)
+	}
+	if len(dnsResponse.Questions) != 0 {
+		dnsResponse.Question = &dnsResponse.Questions[0]
+	}
+	recordCount := int(dnsHeader.AnswerCount) + int(dnsHeader.NameServerRecordsCount) + int(dnsHeader.AdditionalRecordsCount)
+	if recordCount*minRecordLength > responseReader.GetAvailableBytes() {
+		return nil, fmt.Errorf("header claims %d records but only %d bytes remain", recordCount, responseReader.GetAvailableBytes())
+	}
+	dnsResponse.Answers, err = parseRecordsFromResponse(responseReader, dnsHeader.AnswerCount, AnswerSection, options)
+	if err != nil {
+		return nil, err
+	}
+	dnsResponse.NameServerRecords, err = parseRecordsFromResponse(responseReader, dnsHeader.NameServerRecordsCount, AuthoritySection, options)
+	if err != nil {
+		return nil, err
+	}
+	dnsResponse.AdditionalRecords, err = parseRecordsFromResponse(responseReader, dnsHeader.AdditionalRecordsCount, AdditionalSection, options)
+	if err != nil {
+		return nil, err
+	}
+	return dnsResponse, nil
+}
+
+func parseQuestionFromResponse(responseReader *bytereader.ByteReader) (*DnsQueryQuestion, error) {
+	question := &DnsQueryQuestion{}
+	// Questions are rarely compressed, but nothing forbids a server from doing so.
+	questionName, err := responseReader.ReadQname()
+	if err != nil {
+		return nil, err
+	}
+	question.Qname = getDomainNameInQnameFormat(questionName)
+	if question.Qname == nil {
+		return nil, fmt.Errorf("invalid question name %q", questionName)
+	}
+	qtype, err := responseReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	qclass, err := responseReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	question.Qtype = MessageType(qtype)
+	question.Qclass = MessageClass(qclass)
+	return question, nil
+}
+
+// parseRecordsFromResponse reads the count records of a section. Each record is decoded
+// according to its own type, whatever the section it turns up in, and tagged with section;
+// it is up to the callers to only use records of the types they expect.
+func parseRecordsFromResponse(responseReader *bytereader.ByteReader, count uint16, section Section, options parseOptions) ([]DnsAnswer, error) {
+	now := options.now
+	if now == nil {
+		now = time.Now
+	}
+	var records []DnsAnswer
+	for i := 0; uint16(i) < count; i++ {
+		record, err := parseAnswersFromResponse(responseReader, options)
+		if err != nil {
+			return nil, err
+		}
+		record.Section = section
+		record.ExpiresAt = now().Add(time.Duration(record.TTL) * time.Second)
+		if options.strict && slices.Contains(obsoleteMessageTypes, record.RecordType) {
+			return nil, fmt.Errorf("obsolete record type %d for %s", record.RecordType, record.Domain)
+		}
+		if options.onRecord != nil {
+			err = options.onRecord(*record)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if options.maxRecords > 0 && len(records) >= options.maxRecords {
+			continue
+		}
+		records = append(records, *record)
+	}
+	return records, nil
 }
 
 func readDomainFromResponse(responseReader *bytereader.ByteReader) string {
@@ -292,34 +1204,655 @@ func readIpAddressFromResponse(addressInBytes []byte) string {
 	return address.String()
 }
 
-func parseAnswersFromResponse(responseReader *bytereader.ByteReader) *DnsAnswer {
-	o, _ := responseReader.ReadSingleByte()
-	isDomainNameCompressedInAnswer := int(o)&192 == 192
-	var originalReaderPosition int
-	if isDomainNameCompressedInAnswer {
-		o2, _ := responseReader.ReadSingleByte()
-		offset := int(o)&63 + int(o2)
-		originalReaderPosition = responseReader.GetCurrentPosition()
-		_ = responseReader.SeekPosition(offset, io.SeekStart)
-	}
-	domainFromResponse := readDomainFromResponse(responseReader)
-	if isDomainNameCompressedInAnswer {
-		_ = responseReader.SeekPosition(originalReaderPosition, io.SeekStart)
+// parseAnswersFromResponse parses a single record. Whatever the record data decoder leaves
+// unread is skipped, so the next record is read from where RDLENGTH says it starts, unless
+// parsing strictly in which case it is an error.
+func parseAnswersFromResponse(responseReader *bytereader.ByteReader, options parseOptions) (*DnsAnswer, error) {
+	domainFromResponse, err := responseReader.ReadQname()
+	if err != nil {
+		return nil, err
 	}
 	rt, _ := responseReader.ReadUint16()
 	rc, _ := responseReader.ReadUint16()
 	ttl, _ := responseReader.ReadUint32()
-	dataLength, _ := responseReader.ReadUint16()
-	rdata, _ := responseReader.ReadBytes(int(dataLength))
-	ipAddress := readIpAddressFromResponse(rdata)
+	dataLength, err := responseReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
 	ans := &DnsAnswer{
 		Domain:      domainFromResponse,
 		RecordClass: MessageClass(rc),
 		RecordType:  MessageType(rt),
 		TTL:         ttl,
-		Address:     ipAddress,
 	}
-	return ans
+	rdataReader, err := responseReader.SubReader(int(dataLength))
+	if err != nil {
+		return nil, err
+	}
+	err = decodeRdata(ans, rdataReader)
+	if err != nil {
+		return nil, err
+	}
+	if unread := rdataReader.GetAvailableBytes(); unread > 0 && options.strict {
+		return nil, fmt.Errorf("%d of %d bytes of record data left unread for %s record type %d", unread, dataLength, ans.Domain, ans.RecordType)
+	}
+	return ans, nil
+}
+
+// euiLength returns the length of the identifier held by EUI48 and EUI64 records (RFC 7043).
+func euiLength(recordType MessageType) int {
+	if recordType == EUI64 {
+		return 8
+	}
+	return 6
+}
+
+// hasRecordData reports whether the field holding the data of the record's type is set.
+func (a DnsAnswer) hasRecordData() bool {
+	switch a.RecordType {
+	case MX:
+		return a.Mx != nil
+	case SOA:
+		return a.Soa != nil
+	case MINFO:
+		return a.Minfo != nil
+	case HINFO:
+		return a.Hinfo != nil
+	case CAA:
+		return a.Caa != nil
+	case RP:
+		return a.Rp != nil
+	case AFSDB:
+		return a.Afsdb != nil
+	case RT:
+		return a.Rt != nil
+	case SRV:
+		return a.Srv != nil
+	case APL:
+		return a.Apl != nil
+	case NSEC:
+		return a.Nsec != nil
+	case RRSIG:
+		return a.Rrsig != nil
+	case DNSKEY:
+		return a.Dnskey != nil
+	case DS:
+		return a.Ds != nil
+	case LOC:
+		return a.Loc != nil
+	case NAPTR:
+		return a.Naptr != nil
+	case TLSA:
+		return a.Tlsa != nil
+	case SVCB, HTTPS:
+		return a.Svcb != nil
+	case CERT:
+		return a.Cert != nil
+	case SSHFP:
+		return a.Sshfp != nil
+	}
+	return true
+}
+
+// appendApl appends the address prefix list of an APL record to b.
+func appendApl(b []byte, apl *AplData) ([]byte, error) {
+	for _, prefix := range apl.Prefixes {
+		if int(prefix.AfdLength) != len(prefix.AfdPart) || prefix.AfdLength > 127 {
+			return nil, fmt.Errorf("invalid address length %d for an address part of %d bytes", prefix.AfdLength, len(prefix.AfdPart))
+		}
+		afdLength := prefix.AfdLength
+		if prefix.Negation {
+			afdLength |= 128
+		}
+		b = append(b, utils.ConvertUint16ToBytesArray(prefix.Family)...)
+		b = append(b, prefix.PrefixLength, afdLength)
+		b = append(b, prefix.AfdPart...)
+	}
+	return b, nil
+}
+
+// appendTypeBitmap appends types to b as the window blocks of a type bitmap (RFC 4034
+// section 4.1.2).
+func appendTypeBitmap(b []byte, types []MessageType) []byte {
+	sorted := slices.Clone(types)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap []byte
+		for ; i < len(sorted) && sorted[i]>>8 == window; i++ {
+			low := int(sorted[i] & 255)
+			for len(bitmap) <= low/8 {
+				bitmap = append(bitmap, 0)
+			}
+			bitmap[low/8] |= 128 >> (low % 8)
+		}
+		b = append(b, byte(window), byte(len(bitmap)))
+		b = append(b, bitmap...)
+	}
+	return b
+}
+
+// checkCharacterStringCount checks that X25 records hold one character string and ISDN
+// records one or two, an address and a subaddress (RFC 1183 section 3).
+func checkCharacterStringCount(recordType MessageType, count int) error {
+	if recordType == X25 && count != 1 || recordType == ISDN && (count < 1 || count > 2) {
+		return fmt.Errorf("invalid number of character strings %d for record type %d", count, recordType)
+	}
+	return nil
+}
+
+// decodeRdata decodes the record data of ans according to its type. rdataReader is bounded
+// to the record data, so a decoder can't read into the next record.
+func decodeRdata(ans *DnsAnswer, rdataReader *bytereader.ByteReader) error {
+	var err error
+	switch ans.RecordType {
+	case A, AAAA:
+		rdata, _ := rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+		if ans.RecordType == A && len(rdata) == 4 {
+			ans.Address = readIpAddressFromResponse(rdata)
+		} else if ans.RecordType == AAAA && len(rdata) == 16 {
+			// Unlike net.IP, netip keeps IPv4-mapped addresses in IPv6 notation, which GetBytes
+			// needs to tell them from A record addresses.
+			ans.Address = netip.AddrFrom16([16]byte(rdata)).String()
+		} else {
+			return fmt.Errorf("invalid address length %d for record type %d", len(rdata), ans.RecordType)
+		}
+	case EUI48, EUI64:
+		rdata, _ := rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+		if len(rdata) != euiLength(ans.RecordType) {
+			return fmt.Errorf("invalid identifier length %d for record type %d", len(rdata), ans.RecordType)
+		}
+		ans.Address = net.HardwareAddr(rdata).String()
+	case NS, MD, MF, CNAME, MB, MG, MR, PTR:
+		ans.Target, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case SOA:
+		ans.Soa = &SoaData{}
+		for _, name := range []*string{&ans.Soa.Mname, &ans.Soa.Rname} {
+			*name, err = rdataReader.ReadQname()
+			if err != nil {
+				return err
+			}
+		}
+		for _, field := range []*uint32{&ans.Soa.Serial, &ans.Soa.Refresh, &ans.Soa.Retry, &ans.Soa.Expire, &ans.Soa.Minimum} {
+			*field, err = rdataReader.ReadUint32()
+			if err != nil {
+				return err
+			}
+		}
+	case MINFO:
+		ans.Minfo = &MinfoData{}
+		ans.Minfo.Rmailbx, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+		ans.Minfo.Emailbx, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case RP:
+		ans.Rp = &RpData{}
+		ans.Rp.Mbox, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+		ans.Rp.Txt, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case AFSDB:
+		ans.Afsdb = &AfsdbData{}
+		ans.Afsdb.Subtype, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Afsdb.Hostname, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case RT:
+		ans.Rt = &RtData{}
+		ans.Rt.Preference, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Rt.IntermediateHost, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case MX:
+		ans.Mx = &MxData{}
+		ans.Mx.Preference, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Mx.Exchange, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case SRV:
+		ans.Srv = &SrvData{}
+		for _, field := range []*uint16{&ans.Srv.Priority, &ans.Srv.Weight, &ans.Srv.Port} {
+			*field, err = rdataReader.ReadUint16()
+			if err != nil {
+				return err
+			}
+		}
+		ans.Srv.Target, err = rdataReader.ReadQname()
+		if err != nil {
+			return err
+		}
+	case TXT, SPF, X25, ISDN:
+		for rdataReader.GetAvailableBytes() > 0 {
+			txt, err := rdataReader.ReadCharacterString()
+			if err != nil {
+				return err
+			}
+			ans.Txt = append(ans.Txt, txt)
+		}
+		err = checkCharacterStringCount(ans.RecordType, len(ans.Txt))
+		if err != nil {
+			return err
+		}
+	case HINFO:
+		ans.Hinfo = &HinfoData{}
+		ans.Hinfo.Cpu, err = rdataReader.ReadCharacterString()
+		if err != nil {
+			return err
+		}
+		ans.Hinfo.Os, err = rdataReader.ReadCharacterString()
+		if err != nil {
+			return err
+		}
+	case CAA:
+		ans.Caa = &CaaData{}
+		ans.Caa.Flags, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Caa.Tag, err = rdataReader.ReadCharacterString()
+		if err != nil {
+			return err
+		}
+		value, _ := rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+		ans.Caa.Value = string(value)
+	case APL:
+		ans.Apl, err = decodeApl(rdataReader)
+		if err != nil {
+			return err
+		}
+	case NSEC:
+		ans.Nsec = &NsecData{}
+		ans.Nsec.NextDomain, err = rdataReader.ReadUncompressedQname()
+		if err != nil {
+			return err
+		}
+		ans.Nsec.Types, err = decodeTypeBitmap(rdataReader)
+		if err != nil {
+			return err
+		}
+	case LOC:
+		ans.Loc, err = decodeLoc(rdataReader)
+		if err != nil {
+			return err
+		}
+	case NAPTR:
+		ans.Naptr, err = decodeNaptr(rdataReader)
+		if err != nil {
+			return err
+		}
+	case SVCB, HTTPS:
+		ans.Svcb, err = decodeSvcb(rdataReader)
+		if err != nil {
+			return err
+		}
+	case RRSIG:
+		ans.Rrsig, err = decodeRrsig(rdataReader)
+		if err != nil {
+			return err
+		}
+	case DNSKEY:
+		ans.Dnskey = &DnskeyData{}
+		ans.Dnskey.Flags, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Dnskey.Protocol, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Dnskey.Algorithm, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Dnskey.PublicKey, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	case DS:
+		ans.Ds = &DsData{}
+		ans.Ds.KeyTag, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Ds.Algorithm, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Ds.DigestType, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Ds.Digest, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	case SSHFP:
+		ans.Sshfp = &SshfpData{}
+		ans.Sshfp.Algorithm, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Sshfp.FingerprintType, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		fingerprint, _ := rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+		ans.Sshfp.Fingerprint = hex.EncodeToString(fingerprint)
+	case CERT:
+		ans.Cert = &CertData{}
+		ans.Cert.Type, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Cert.KeyTag, err = rdataReader.ReadUint16()
+		if err != nil {
+			return err
+		}
+		ans.Cert.Algorithm, err = rdataReader.ReadSingleByte()
+		if err != nil {
+			return err
+		}
+		ans.Cert.Certificate, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	case OPT:
+		for rdataReader.GetAvailableBytes() > 0 {
+			option := EdnsOption{}
+			option.Code, err = rdataReader.ReadUint16()
+			if err != nil {
+				return err
+			}
+			optionLength, err := rdataReader.ReadUint16()
+			if err != nil {
+				return err
+			}
+			option.Data, err = rdataReader.ReadBytes(int(optionLength))
+			if err != nil {
+				return err
+			}
+			ans.EdnsOptions = append(ans.EdnsOptions, option)
+		}
+	case TLSA:
+		ans.Tlsa = &TlsaData{}
+		for _, field := range []*uint8{&ans.Tlsa.Usage, &ans.Tlsa.Selector, &ans.Tlsa.MatchingType} {
+			*field, err = rdataReader.ReadSingleByte()
+			if err != nil {
+				return err
+			}
+		}
+		ans.Tlsa.CertificateAssociationData, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	default:
+		ans.RawData, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	}
+	return nil
+}
+
+// decodeTypeBitmap decodes the window blocks of a type bitmap (RFC 4034 section 4.1.2) that
+// make up the rest of the record data. The blocks must come in increasing order of their
+// window, so that the types are listed in increasing order too.
+func decodeTypeBitmap(rdataReader *bytereader.ByteReader) ([]MessageType, error) {
+	var types []MessageType
+	previousWindow := -1
+	for rdataReader.GetAvailableBytes() > 0 {
+		window, err := rdataReader.ReadSingleByte()
+		if err != nil {
+			return nil, err
+		}
+		if int(window) <= previousWindow {
+			return nil, fmt.Errorf("type bitmap window %d after window %d", window, previousWindow)
+		}
+		previousWindow = int(window)
+		length, err := rdataReader.ReadSingleByte()
+		if err != nil {
+			return nil, err
+		}
+		if length == 0 || length > 32 {
+			return nil, fmt.Errorf("invalid type bitmap length %d", length)
+		}
+		bitmap, err := rdataReader.ReadBytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		for i, octet := range bitmap {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(128>>bit) != 0 {
+					types = append(types, MessageType(int(window)<<8|i*8+bit))
+				}
+			}
+		}
+	}
+	return types, nil
+}
+
+func decodeLoc(rdataReader *bytereader.ByteReader) (*LocData, error) {
+	if rdataReader.GetAvailableBytes() != 16 {
+		return nil, fmt.Errorf("invalid LOC record length %d", rdataReader.GetAvailableBytes())
+	}
+	loc := &LocData{}
+	loc.Version, _ = rdataReader.ReadSingleByte()
+	if loc.Version != 0 {
+		return nil, fmt.Errorf("unsupported LOC version %d", loc.Version)
+	}
+	for _, size := range []*float64{&loc.Size, &loc.HorizontalPrecision, &loc.VerticalPrecision} {
+		encoded, _ := rdataReader.ReadSingleByte()
+		decoded, err := decodeLocSize(encoded)
+		if err != nil {
+			return nil, err
+		}
+		*size = decoded
+	}
+	// Latitude and longitude are thousandths of an arc second offset by 2^31 so that the
+	// equator and the prime meridian lie in the middle of the range. The altitude is in
+	// centimeters above 100,000 meters below the reference spheroid.
+	latitude, _ := rdataReader.ReadUint32()
+	longitude, _ := rdataReader.ReadUint32()
+	altitude, _ := rdataReader.ReadUint32()
+	loc.Latitude = float64(int64(latitude)-1<<31) / 3600000
+	loc.Longitude = float64(int64(longitude)-1<<31) / 3600000
+	loc.Altitude = float64(int64(altitude)-10000000) / 100
+	return loc, nil
+}
+
+// appendLoc appends the location of a LOC record to b, rounded to what the wire format can
+// hold: sizes to a single significant digit, coordinates to thousandths of an arc second and
+// the altitude to centimeters.
+func appendLoc(b []byte, loc *LocData) ([]byte, error) {
+	if loc.Version != 0 {
+		return nil, fmt.Errorf("unsupported LOC version %d", loc.Version)
+	}
+	// Coordinates beyond the poles or the antimeridian are written as they were received, as
+	// long as they fit.
+	latitude := math.Round(loc.Latitude*3600000) + 1<<31
+	longitude := math.Round(loc.Longitude*3600000) + 1<<31
+	altitude := math.Round(loc.Altitude*100) + 10000000
+	for _, value := range []float64{latitude, longitude, altitude} {
+		if value < 0 || value > math.MaxUint32 {
+			return nil, fmt.Errorf("LOC coordinates %v, %v and altitude %vm out of range", loc.Latitude, loc.Longitude, loc.Altitude)
+		}
+	}
+	b = append(b, loc.Version)
+	for _, size := range []float64{loc.Size, loc.HorizontalPrecision, loc.VerticalPrecision} {
+		encoded, err := encodeLocSize(size)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, encoded)
+	}
+	b = append(b, utils.ConvertUint32ToBytesArray(uint32(latitude))...)
+	b = append(b, utils.ConvertUint32ToBytesArray(uint32(longitude))...)
+	return append(b, utils.ConvertUint32ToBytesArray(uint32(altitude))...), nil
+}
+
+// encodeLocSize encodes a size or precision in meters the way decodeLocSize decodes it.
+func encodeLocSize(meters float64) (byte, error) {
+	centimeters := math.Round(meters * 100)
+	if centimeters < 0 || centimeters > 9e9 {
+		return 0, fmt.Errorf("LOC size %vm out of range", meters)
+	}
+	exponent := 0
+	base := centimeters
+	for base > 9 {
+		exponent++
+		base = math.Round(centimeters / math.Pow10(exponent))
+	}
+	return byte(base)<<4 | byte(exponent), nil
+}
+
+// decodeLocSize decodes a size or precision of a LOC record, a base in the high nibble times
+// ten to the power of the low nibble, in centimeters.
+func decodeLocSize(encoded byte) (float64, error) {
+	base, exponent := encoded>>4, encoded&15
+	if base > 9 || exponent > 9 {
+		return 0, fmt.Errorf("invalid LOC size %#x", encoded)
+	}
+	return float64(base) * math.Pow10(int(exponent)) / 100, nil
+}
+
+func decodeNaptr(rdataReader *bytereader.ByteReader) (*NaptrData, error) {
+	naptr := &NaptrData{}
+	var err error
+	naptr.Order, err = rdataReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	naptr.Preference, err = rdataReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	for _, characterString := range []*string{&naptr.Flags, &naptr.Service, &naptr.Regexp} {
+		*characterString, err = rdataReader.ReadCharacterString()
+		if err != nil {
+			return nil, err
+		}
+	}
+	naptr.Replacement, err = rdataReader.ReadQname()
+	if err != nil {
+		return nil, err
+	}
+	return naptr, nil
+}
+
+func decodeSvcb(rdataReader *bytereader.ByteReader) (*SvcbData, error) {
+	svcb := &SvcbData{}
+	var err error
+	svcb.Priority, err = rdataReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	// The target name must not be compressed (RFC 9460 section 2.2).
+	svcb.Target, err = rdataReader.ReadUncompressedQname()
+	if err != nil {
+		return nil, err
+	}
+	for rdataReader.GetAvailableBytes() > 0 {
+		key, err := rdataReader.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		length, err := rdataReader.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		value, err := rdataReader.ReadBytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range svcb.Params {
+			if param.Key == key {
+				return nil, fmt.Errorf("duplicate SvcParamKey %d", key)
+			}
+		}
+		svcb.Params = append(svcb.Params, SvcParam{Key: key, Value: value})
+	}
+	return svcb, nil
+}
+
+func decodeRrsig(rdataReader *bytereader.ByteReader) (*RrsigData, error) {
+	rrsig := &RrsigData{}
+	typeCovered, err := rdataReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.TypeCovered = MessageType(typeCovered)
+	rrsig.Algorithm, err = rdataReader.ReadSingleByte()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.Labels, err = rdataReader.ReadSingleByte()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.OriginalTTL, err = rdataReader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.Expiration, err = rdataReader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.Inception, err = rdataReader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.KeyTag, err = rdataReader.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+	// The signer name must not be compressed (RFC 4034 section 3.1.7).
+	rrsig.SignerName, err = rdataReader.ReadUncompressedQname()
+	if err != nil {
+		return nil, err
+	}
+	rrsig.Signature, _ = rdataReader.ReadBytes(rdataReader.GetAvailableBytes())
+	return rrsig, nil
+}
+
+func decodeApl(rdataReader *bytereader.ByteReader) (*AplData, error) {
+	apl := &AplData{}
+	for rdataReader.GetAvailableBytes() > 0 {
+		prefix := AplPrefix{}
+		family, err := rdataReader.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		prefixLength, err := rdataReader.ReadSingleByte()
+		if err != nil {
+			return nil, err
+		}
+		afdLength, err := rdataReader.ReadSingleByte()
+		if err != nil {
+			return nil, err
+		}
+		prefix.Family = family
+		prefix.PrefixLength = prefixLength
+		prefix.Negation = afdLength&128 == 128
+		prefix.AfdLength = afdLength & 127
+		if family == 1 && prefix.AfdLength > 4 || family == 2 && prefix.AfdLength > 16 {
+			return nil, fmt.Errorf("invalid address length %d for address family %d", prefix.AfdLength, family)
+		}
+		prefix.AfdPart, err = rdataReader.ReadBytes(int(prefix.AfdLength))
+		if err != nil {
+			return nil, err
+		}
+		apl.Prefixes = append(apl.Prefixes, prefix)
+	}
+	return apl, nil
 }
 
 func populateDnsHeaderWithMetadata(headerMeta uint16, dnsHeader *DnsHeader) error {
@@ -329,6 +1862,7 @@ func populateDnsHeaderWithMetadata(headerMeta uint16, dnsHeader *DnsHeader) erro
 	dnsHeader.IsTruncatedMessage = headerMeta&uint16(512) == uint16(512)
 	dnsHeader.IsRecursionDesired = headerMeta&uint16(256) == uint16(256)
 	dnsHeader.IsRecursionSupportAvailable = headerMeta&uint16(128) == uint16(128)
+	dnsHeader.IsAuthenticData = headerMeta&uint16(32) == uint16(32)
 	dnsHeader.ResponseCode = ResponseCode(headerMeta & uint16(15))
 	return nil
 }
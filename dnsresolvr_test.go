@@ -1,12 +1,61 @@
 package dnsresolvr
 
 import (
+	"bytes"
+	"context"
+	"dnsresolvr/internal/pkg/bytereader"
+	"dnsresolvr/internal/pkg/utils"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
+func TestSplitDomainLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "www.example.com", want: []string{"www", "example", "com"}},
+		{name: "www.example.com.", want: []string{"www", "example", "com"}},
+		{name: ".", want: nil},
+		{name: "", want: nil},
+		{name: "www..example.com", wantErr: true},
+		{name: ".example.com", wantErr: true},
+		{name: "example.com..", wantErr: true},
+		{name: strings.Repeat("a", 64) + ".com", wantErr: true},
+		{name: strings.Repeat(strings.Repeat("a", 63)+".", 4), wantErr: true},
+		{name: strings.Repeat("a.", 200), wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := splitDomainLabels(test.name)
+		if (err != nil) != test.wantErr || !slices.Equal(got, test.want) {
+			t.Fatalf("Got: %q (%v) for %q, Want: %q, error %t", got, err, test.name, test.want, test.wantErr)
+		}
+	}
+	if got := getDomainNameInQnameFormat("www..example.com"); got != nil {
+		t.Fatalf("Got: %x, Want: nil for a name with an empty label", got)
+	}
+	if _, err := splitDomainLabels(strings.Repeat("a.", 200)); err == nil || !strings.Contains(err.Error(), "200 labels") {
+		t.Fatalf("Got error: %v, Want: 200 label name rejected for its label count", err)
+	}
+	if got := getDomainNameInQnameFormat(strings.Repeat("a.", 200)); got != nil {
+		t.Fatalf("Got: %x, Want: nil for a 200 label name", got)
+	}
+	resolver := &Resolver{Servers: []string{"127.0.0.1"}}
+	_, err := resolver.ResolveType("www..example.com", A)
+	if err == nil || !strings.Contains(err.Error(), "empty label") {
+		t.Fatalf("Got error: %v, Want: empty label rejected before querying", err)
+	}
+}
+
 func TestQnameBytesFromDomainName(t *testing.T) {
 	got := getDomainNameInQnameFormat("dns.google.com")
 	want, _ := hex.DecodeString("03646e7306676f6f676c6503636f6d00")
@@ -15,8 +64,17 @@ func TestQnameBytesFromDomainName(t *testing.T) {
 	}
 }
 
+func TestQnameBytesFromRootDomainName(t *testing.T) {
+	for _, domainName := range []string{"", "."} {
+		got := getDomainNameInQnameFormat(domainName)
+		if !slices.Equal(got, []byte{0}) {
+			t.Fatalf("Got: %s, Want: 00 for %q", hex.EncodeToString(got), domainName)
+		}
+	}
+}
+
 func TestQueryBytesInHex(t *testing.T) {
-	query := generateDnsQuery("dns.google.com")
+	query := generateDnsQuery("dns.google.com", A)
 	got := hex.EncodeToString(query.GetBytes())
 	want := "0000000100000000000003646e7306676f6f676c6503636f6d0000010001"
 	if !strings.Contains(got, want) {
@@ -24,7 +82,1570 @@ func TestQueryBytesInHex(t *testing.T) {
 	}
 }
 
+func TestNewQueryFromQuestion(t *testing.T) {
+	original := generateDnsQuery("www.example.com", AAAA)
+	response, err := ParseResponse(original.GetBytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	query := NewQueryFromQuestion(*response.Question)
+	queryBytes := query.GetBytes()
+	if !bytes.Equal(queryBytes[12:], original.GetBytes()[12:]) {
+		t.Fatalf("Got question: %x, Want: %x", queryBytes[12:], original.GetBytes()[12:])
+	}
+	if query.Header.QuestionCount != 1 || query.Header.Opcode != StandardQuery || query.Header.IsResponse {
+		t.Fatalf("Got header: %+v, Want: a standard query with one question", query.Header)
+	}
+	response.Question.Qname[1] = 'W'
+	if query.Questions[0].GetDomainName() != "www.example.com" {
+		t.Fatalf("Got: %s, Want: the query not to share the name of the response", query.Questions[0].GetDomainName())
+	}
+}
+
+func TestValidateRejectsDuplicateQuestions(t *testing.T) {
+	query := generateDnsQuery("www.example.com", A)
+	query.Questions = append(query.Questions, generateDnsQuery("www.example.com", AAAA).Questions[0])
+	query.Header.QuestionCount = 2
+	err := query.Validate()
+	if err != nil {
+		t.Fatalf("Unexpected error for distinct questions: %v", err)
+	}
+	query.Questions = append(query.Questions, generateDnsQuery("WWW.Example.com.", A).Questions[0])
+	query.Header.QuestionCount = 3
+	err = query.Validate()
+	if err == nil || !strings.Contains(err.Error(), "duplicate question") {
+		t.Fatalf("Got error: %v, Want: duplicate www.example.com A question", err)
+	}
+	resolver := &Resolver{Servers: []string{"127.0.0.1"}}
+	_, err = resolver.exchange(context.Background(), query, "127.0.0.1")
+	if err == nil {
+		t.Fatalf("Got no error, Want: query with a duplicate question not sent")
+	}
+}
+
 func TestQueryDns(t *testing.T) {
-	response, _ := queryDns("dns.google.com")
-	parseResponse(response)
+	response, err := queryDns(context.Background(), generateDnsQuery("dns.google.com", A), "198.41.0.4:53", defaultTimeout, defaultUdpPayloadSize)
+	if err != nil {
+		t.Skipf("Root name server not reachable: %v", err)
+	}
+	_, err = ParseResponse(response)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+}
+
+func TestAnswerIP(t *testing.T) {
+	tests := []struct {
+		answer DnsAnswer
+		want   net.IP
+	}{
+		{DnsAnswer{RecordType: A, Address: "93.184.216.34"}, net.ParseIP("93.184.216.34")},
+		{DnsAnswer{RecordType: AAAA, Address: "2606:2800:220:1::248"}, net.ParseIP("2606:2800:220:1::248")},
+		{DnsAnswer{RecordType: NS, Target: "ns.example.com"}, nil},
+	}
+	for _, test := range tests {
+		got := test.answer.IP()
+		if !got.Equal(test.want) {
+			t.Fatalf("Got: %v, Want: %v for record type %d", got, test.want, test.answer.RecordType)
+		}
+	}
+}
+
+func TestQueryDnsIgnoresSpoofedResponses(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Could not start mock server: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	go func() {
+		buffer := make([]byte, 512)
+		n, remote, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		query, _ := ParseResponse(buffer[:n])
+		reply := testReply{answers: []testRecord{aRecord("dns.google.com", "8.8.8.8")}}
+		spoofed := testReply{answers: []testRecord{aRecord("dns.google.com", "6.6.6.6")}}.bytesFor(query)
+		spoofed[0] ^= 0xff
+		otherQuestion := &DnsResponse{Header: query.Header, Question: &DnsQueryQuestion{
+			Qname:  getDomainNameInQnameFormat("evil.com"),
+			Qtype:  A,
+			Qclass: IN,
+		}}
+		_, _ = conn.WriteToUDP(spoofed, remote)
+		_, _ = conn.WriteToUDP(testReply{}.bytesFor(otherQuestion), remote)
+		_, _ = conn.WriteToUDP(reply.bytesFor(query), remote)
+	}()
+	responseBytes, err := queryDns(context.Background(), generateDnsQuery("dns.google.com", A),
+		conn.LocalAddr().String(), defaultTimeout, defaultUdpPayloadSize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "8.8.8.8" {
+		t.Fatalf("Got answers: %v, Want: 8.8.8.8", response.Answers)
+	}
+}
+
+// unconnectedUDPConn sends to remote over a socket that receives datagrams from anyone, like
+// a socket a custom dialer might hand out.
+type unconnectedUDPConn struct {
+	*net.UDPConn
+	remote *net.UDPAddr
+}
+
+func (c unconnectedUDPConn) Write(b []byte) (int, error) {
+	return c.WriteToUDP(b, c.remote)
+}
+
+func (c unconnectedUDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestQueryDnsIgnoresResponsesFromOtherAddresses(t *testing.T) {
+	spoofer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Could not open spoofing socket: %v", err)
+	}
+	defer func() {
+		_ = spoofer.Close()
+	}()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Could not open client socket: %v", err)
+	}
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		spoofed := testReply{answers: []testRecord{aRecord("dns.google.com", "6.6.6.6")}}.bytesFor(query)
+		_, _ = spoofer.WriteToUDP(spoofed, client.LocalAddr().(*net.UDPAddr))
+		return testReply{answers: []testRecord{aRecord("dns.google.com", "8.8.8.8")}}.bytesFor(query)
+	})
+	dial := func(_ context.Context, _ string, _ string) (net.Conn, error) {
+		return unconnectedUDPConn{UDPConn: client, remote: server.conn.LocalAddr().(*net.UDPAddr)}, nil
+	}
+	responseBytes, err := queryDnsWithDialer(context.Background(), dial, generateDnsQuery("dns.google.com", A),
+		server.address(), defaultTimeout, defaultUdpPayloadSize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "8.8.8.8" {
+		t.Fatalf("Got answers: %v, Want: 8.8.8.8 from the server", response.Answers)
+	}
+}
+
+func TestIsResponseToQueryWithoutQuestion(t *testing.T) {
+	query := generateDnsQuery("www.example.com", A)
+	tests := []struct {
+		rcode ResponseCode
+		want  bool
+	}{
+		{FormatError, true},
+		{NotImplemented, true},
+		{NameError, false},
+		{ServerFailure, false},
+		{NoError, false},
+	}
+	for _, test := range tests {
+		header := DnsHeader{Id: query.Header.Id, IsResponse: true, ResponseCode: test.rcode}
+		if got := isResponseToQuery(query, header.GetBytes()); got != test.want {
+			t.Fatalf("Got: %t, Want: %t for a response without question with rcode %d", got, test.want, test.rcode)
+		}
+	}
+}
+
+// parseTestRecords parses a response to an example.com question carrying the records as
+// answers. The question name sits at offset 12, so 0xc00c in rdata points to example.com.
+func parseTestRecords(t *testing.T, records ...testRecord) []DnsAnswer {
+	t.Helper()
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	response, err := ParseResponse(testReply{answers: records}.bytesFor(request))
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(response.Answers) != len(records) {
+		t.Fatalf("Got %d answers, Want: %d", len(response.Answers), len(records))
+	}
+	return response.Answers
+}
+
+func TestParseMailboxRecords(t *testing.T) {
+	compressedMailbox := []byte{5, 'a', 'd', 'm', 'i', 'n', 0xc0, 0x0c}
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: MB, ttl: 300, rdata: compressedMailbox},
+		testRecord{name: "example.com", rtype: MG, ttl: 300, rdata: getDomainNameInQnameFormat("group.example.com")},
+		testRecord{name: "example.com", rtype: MR, ttl: 300, rdata: compressedMailbox},
+	)
+	for i, want := range []string{"admin.example.com", "group.example.com", "admin.example.com"} {
+		if answers[i].Target != want {
+			t.Fatalf("Got: %s, Want: %s for record type %d", answers[i].Target, want, answers[i].RecordType)
+		}
+	}
+}
+
+// testSoaRdata returns the data of an SOA record of example.com whose names point to the
+// question, example.com at offset 12.
+func testSoaRdata() []byte {
+	rdata := []byte{3, 'n', 's', '1', 0xc0, 0x0c, 10, 'h', 'o', 's', 't', 'm', 'a', 's', 't', 'e', 'r', 0xc0, 0x0c}
+	return append(rdata, 0x78, 0x49, 0x5f, 0x01, 0, 0, 0x1c, 0x20, 0, 0, 0x0e, 0x10, 0, 0x12, 0x75, 0, 0, 0, 0x0e, 0x10)
+}
+
+func TestParseSoaRecord(t *testing.T) {
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: SOA, ttl: 300, rdata: testSoaRdata()})
+	want := SoaData{Mname: "ns1.example.com", Rname: "hostmaster.example.com", Serial: 2018074369,
+		Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 3600}
+	if answers[0].Soa == nil || *answers[0].Soa != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Soa, want)
+	}
+	wantPresentation := "ns1.example.com. hostmaster.example.com. 2018074369 7200 3600 1209600 3600"
+	if got := answers[0].RdataPresentation(); got != wantPresentation {
+		t.Fatalf("Got: %q, Want: %q", got, wantPresentation)
+	}
+}
+
+// TestCompressedRecordsUnderAnotherQuestion moves records whose names point into the message
+// they were parsed from into a message of a different layout, as forwarding does.
+func TestCompressedRecordsUnderAnotherQuestion(t *testing.T) {
+	records := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: PTR, ttl: 300, rdata: []byte{3, 'w', 'w', 'w', 0xc0, 0x0c}},
+		testRecord{name: "example.com", rtype: SOA, ttl: 300, rdata: testSoaRdata()})
+	response := buildErrorResponse(generateDnsQuery("a.b.c.d.other.org", A), NameError)
+	response.Answers = records[:1]
+	response.NameServerRecords = records[1:]
+	responseBytes, err := response.GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing response: %v", err)
+	}
+	reparsed, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(reparsed.Answers) != 1 || reparsed.Answers[0].Target != "www.example.com" {
+		t.Fatalf("Got answers: %+v, Want: PTR to www.example.com", reparsed.Answers)
+	}
+	if len(reparsed.NameServerRecords) != 1 || !reflect.DeepEqual(reparsed.NameServerRecords[0].Soa, records[1].Soa) {
+		t.Fatalf("Got authority records: %+v, Want: SOA %+v", reparsed.NameServerRecords, *records[1].Soa)
+	}
+}
+
+func TestParseMinfoRecord(t *testing.T) {
+	rdata := append([]byte{5, 'l', 'i', 's', 't', 's', 0xc0, 0x0c}, getDomainNameInQnameFormat("errors.example.com")...)
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: MINFO, ttl: 300, rdata: rdata})
+	want := MinfoData{Rmailbx: "lists.example.com", Emailbx: "errors.example.com"}
+	if answers[0].Minfo == nil || *answers[0].Minfo != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Minfo, want)
+	}
+}
+
+func TestParseRpRecord(t *testing.T) {
+	rdata := append([]byte{5, 'a', 'd', 'm', 'i', 'n', 0xc0, 0x0c}, getDomainNameInQnameFormat("info.example.com")...)
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: RP, ttl: 300, rdata: rdata})
+	want := RpData{Mbox: "admin.example.com", Txt: "info.example.com"}
+	if answers[0].Rp == nil || *answers[0].Rp != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Rp, want)
+	}
+	recordBytes, err := answers[0].GetBytes(newCompressionContext(12))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if wantRdata := append(getDomainNameInQnameFormat("admin.example.com"), getDomainNameInQnameFormat("info.example.com")...); !bytes.HasSuffix(recordBytes, wantRdata) {
+		t.Fatalf("Got: %x, Want the names written uncompressed: %x", recordBytes, wantRdata)
+	}
+}
+
+func TestParseAfsdbRecord(t *testing.T) {
+	rdata := append([]byte{0, 2}, getDomainNameInQnameFormat("dce.example.com")...)
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: AFSDB, ttl: 300, rdata: rdata})
+	want := AfsdbData{Subtype: 2, Hostname: "dce.example.com"}
+	if answers[0].Afsdb == nil || *answers[0].Afsdb != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Afsdb, want)
+	}
+}
+
+func TestParseX25Record(t *testing.T) {
+	answers := parseTestRecords(t, testRecord{name: "relay.example.com", rtype: X25, ttl: 300, rdata: []byte{12, '3', '1', '1', '0', '6', '1', '7', '0', '0', '9', '5', '6'}})
+	if !slices.Equal(answers[0].Txt, []string{"311061700956"}) {
+		t.Fatalf("Got: %v, Want: [311061700956]", answers[0].Txt)
+	}
+}
+
+func TestParseIsdnRecord(t *testing.T) {
+	answers := parseTestRecords(t,
+		testRecord{name: "isdn.example.com", rtype: ISDN, ttl: 300, rdata: []byte{4, '1', '5', '5', '5', 3, '0', '0', '4'}},
+		testRecord{name: "isdn.example.com", rtype: ISDN, ttl: 300, rdata: []byte{4, '1', '5', '5', '5'}},
+	)
+	if !slices.Equal(answers[0].Txt, []string{"1555", "004"}) {
+		t.Fatalf("Got: %v, Want: address 1555 and subaddress 004", answers[0].Txt)
+	}
+	if !slices.Equal(answers[1].Txt, []string{"1555"}) {
+		t.Fatalf("Got: %v, Want: address 1555 without subaddress", answers[1].Txt)
+	}
+	query := generateDnsQuery("isdn.example.com", ISDN)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	tooMany := testRecord{name: "isdn.example.com", rtype: ISDN, ttl: 300, rdata: []byte{1, '1', 1, '2', 1, '3'}}
+	_, err := ParseResponse(testReply{answers: []testRecord{tooMany}}.bytesFor(request))
+	if err == nil {
+		t.Fatalf("Got no error, Want: ISDN record with three character strings rejected")
+	}
+}
+
+func TestParseRtRecord(t *testing.T) {
+	rdata := append([]byte{0, 10}, getDomainNameInQnameFormat("relay.example.com")...)
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: RT, ttl: 300, rdata: rdata})
+	want := RtData{Preference: 10, IntermediateHost: "relay.example.com"}
+	if answers[0].Rt == nil || *answers[0].Rt != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Rt, want)
+	}
+	recordBytes, err := answers[0].GetBytes(nil)
+	if err != nil || !bytes.HasSuffix(recordBytes, rdata) {
+		t.Fatalf("Got: %x (%v), Want record data: %x", recordBytes, err, rdata)
+	}
+}
+
+func TestParseEuiRecords(t *testing.T) {
+	eui48 := []byte{0x00, 0x00, 0x5e, 0x00, 0x53, 0x2a}
+	eui64 := []byte{0x00, 0x00, 0x5e, 0xef, 0x10, 0x00, 0x00, 0x2a}
+	answers := parseTestRecords(t,
+		testRecord{name: "host.example.com", rtype: EUI48, ttl: 300, rdata: eui48},
+		testRecord{name: "host.example.com", rtype: EUI64, ttl: 300, rdata: eui64},
+	)
+	if answers[0].Address != "00:00:5e:00:53:2a" || answers[1].Address != "00:00:5e:ef:10:00:00:2a" {
+		t.Fatalf("Got: %s and %s, Want: the EUI48 and EUI64 identifiers", answers[0].Address, answers[1].Address)
+	}
+	for i, rdata := range [][]byte{eui48, eui64} {
+		recordBytes, err := answers[i].GetBytes(nil)
+		if err != nil || !bytes.HasSuffix(recordBytes, rdata) {
+			t.Fatalf("Got: %x (%v), Want record data: %x", recordBytes, err, rdata)
+		}
+	}
+	query := generateDnsQuery("host.example.com", EUI64)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	_, err := ParseResponse(testReply{answers: []testRecord{{name: "host.example.com", rtype: EUI64, ttl: 300, rdata: eui48}}}.bytesFor(request))
+	if err == nil {
+		t.Fatalf("Got no error, Want: EUI64 record with a 6 byte identifier rejected")
+	}
+	_, err = DnsAnswer{Domain: "host.example.com", RecordType: EUI48, RecordClass: IN, Address: answers[1].Address}.GetBytes(nil)
+	if err == nil {
+		t.Fatalf("Got no error, Want: EUI48 record with an 8 byte identifier rejected")
+	}
+}
+
+func TestParseMailDestinationRecord(t *testing.T) {
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: MD, ttl: 300, rdata: []byte{4, 'm', 'a', 'i', 'l', 0xc0, 0x0c}},
+		aRecord("example.com", "93.184.216.34"),
+	)
+	if answers[0].Target != "mail.example.com" {
+		t.Fatalf("Got: %s, Want: mail.example.com", answers[0].Target)
+	}
+	if answers[1].Address != "93.184.216.34" {
+		t.Fatalf("Got: %s, Want: 93.184.216.34 for the record after MD", answers[1].Address)
+	}
+}
+
+func TestResolveMailQueryTypes(t *testing.T) {
+	records := []DnsAnswer{
+		{Domain: "example.com", RecordType: MB, RecordClass: IN, TTL: 300, Target: "mailbox.example.com"},
+		{Domain: "example.com", RecordType: MG, RecordClass: IN, TTL: 300, Target: "group.example.com"},
+		{Domain: "example.com", RecordType: MR, RecordClass: IN, TTL: 300, Target: "renamed.example.com"},
+		{Domain: "example.com", RecordType: MD, RecordClass: IN, TTL: 300, Target: "destination.example.com"},
+		{Domain: "example.com", RecordType: MF, RecordClass: IN, TTL: 300, Target: "forwarder.example.com"},
+		{Domain: "example.com", RecordType: MX, RecordClass: IN, TTL: 300, Mx: &MxData{Preference: 10, Exchange: "mx.example.com"}},
+	}
+	targets := make(map[MessageType]string)
+	for _, record := range records {
+		targets[record.RecordType] = record.Target
+	}
+	server := startMockServer(t, "127.0.0.1:0", zoneHandler(records))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	tests := []struct {
+		qtype MessageType
+		want  []MessageType
+	}{
+		{MAILB, []MessageType{MB, MG, MR}},
+		{MAILA, []MessageType{MD, MF}},
+	}
+	for _, test := range tests {
+		response, err := resolver.ResolveType("example.com", test.qtype)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", test.qtype, err)
+		}
+		if response.Question.Qtype != test.qtype {
+			t.Fatalf("Got question type %s, Want: %s", response.Question.Qtype, test.qtype)
+		}
+		var got []MessageType
+		for _, answer := range response.Answers {
+			got = append(got, answer.RecordType)
+			if want := targets[answer.RecordType]; answer.Target != want {
+				t.Fatalf("Got target %s for the %s answer, Want: %s", answer.Target, answer.RecordType, want)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Fatalf("Got answer types %v for %s, Want: %v", got, test.qtype, test.want)
+		}
+	}
+}
+
+func TestResolveAnyType(t *testing.T) {
+	query := generateDnsQuery("example.com", ANY_TYPE)
+	queryBytes := query.GetBytes()
+	if qtype := queryBytes[len(queryBytes)-4:]; !bytes.Equal(qtype, []byte{0, 255, 0, 1}) {
+		t.Fatalf("Got type and class: %x, Want: 00ff0001", qtype)
+	}
+	records := []DnsAnswer{
+		{Domain: "example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "93.184.216.34"},
+		{Domain: "example.com", RecordType: MX, RecordClass: IN, TTL: 300, Mx: &MxData{Preference: 10, Exchange: "mx.example.com"}},
+		{Domain: "other.example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "192.0.2.1"},
+	}
+	server := startMockServer(t, "127.0.0.1:0", zoneHandler(records))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("example.com", ANY_TYPE)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Question.Qtype != ANY_TYPE || response.Question.Qtype.String() != "ANY" {
+		t.Fatalf("Got question type %s, Want: ANY", response.Question.Qtype)
+	}
+	if len(response.Answers) != 2 || response.Answers[0].RecordType != A || response.Answers[1].RecordType != MX {
+		t.Fatalf("Got answers: %v, Want: the A and MX records of example.com", response.Answers)
+	}
+}
+
+func TestParseStrictRejectsObsoleteRecordTypes(t *testing.T) {
+	query := generateDnsQuery("example.com", MD)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	mdRecord := testRecord{name: "example.com", rtype: MD, ttl: 300, rdata: getDomainNameInQnameFormat("mail.example.com")}
+	responseBytes := testReply{answers: []testRecord{mdRecord}}.bytesFor(request)
+	response, err := parseResponseWithOptions(responseBytes, parseOptions{})
+	if err != nil || len(response.Answers) != 1 || response.Answers[0].Target != "mail.example.com" {
+		t.Fatalf("Got: %v (%v), Want: the MD record parsed in non-strict mode", response, err)
+	}
+	_, err = parseResponseWithOptions(responseBytes, parseOptions{strict: true})
+	if err == nil {
+		t.Fatalf("Got no error, Want: MD record rejected in strict mode")
+	}
+}
+
+func TestParseResponseStreamStopsEarly(t *testing.T) {
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	responseBytes := testReply{answers: []testRecord{
+		aRecord("example.com", "192.0.2.1"),
+		aRecord("example.com", "192.0.2.2"),
+		aRecord("example.com", "192.0.2.3"),
+	}}.bytesFor(request)
+	var seen []string
+	header, err := ParseResponseStream(responseBytes, func(record DnsAnswer) error {
+		seen = append(seen, record.Address)
+		return nil
+	})
+	if err != nil || header.AnswerCount != 3 || len(seen) != 3 {
+		t.Fatalf("Got header: %v, records: %v (%v), Want: all three records", header, seen, err)
+	}
+	errStop := errors.New("stop")
+	seen = nil
+	_, err = ParseResponseStream(responseBytes, func(record DnsAnswer) error {
+		seen = append(seen, record.Address)
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("Got error: %v, Want: the error returned by the callback", err)
+	}
+	if !slices.Equal(seen, []string{"192.0.2.1"}) {
+		t.Fatalf("Got records: %v, Want: only the first one", seen)
+	}
+	_, err = ParseResponseStream(responseBytes[:len(responseBytes)-2], func(DnsAnswer) error {
+		return nil
+	})
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("Got error: %v, Want: ErrMalformedResponse", err)
+	}
+}
+
+func TestParseSkipsUnreadRecordData(t *testing.T) {
+	query := generateDnsQuery("example.com", MX)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	mxRdata := append([]byte{0, 10}, getDomainNameInQnameFormat("mail.example.com")...)
+	mxRecord := testRecord{name: "example.com", rtype: MX, ttl: 300, rdata: append(mxRdata, 0xde, 0xad)}
+	responseBytes := testReply{answers: []testRecord{mxRecord, aRecord("mail.example.com", "192.0.2.25")}}.bytesFor(request)
+	response, err := parseResponseWithOptions(responseBytes, parseOptions{})
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(response.Answers) != 2 || response.Answers[0].Mx.Exchange != "mail.example.com" || response.Answers[1].Address != "192.0.2.25" {
+		t.Fatalf("Got answers: %v, Want: the MX record and the A record after it", response.Answers)
+	}
+	_, err = parseResponseWithOptions(responseBytes, parseOptions{strict: true})
+	if err == nil || !strings.Contains(err.Error(), "2 of 22 bytes of record data left unread") {
+		t.Fatalf("Got error: %v, Want: unread record data rejected in strict mode", err)
+	}
+}
+
+func TestParseStrictRejectsObsoleteOpcodes(t *testing.T) {
+	query := generateDnsQuery("example.com", A)
+	query.Header.Opcode = InverseQuery
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	responseBytes := testReply{}.bytesFor(request)
+	response, err := parseResponseWithOptions(responseBytes, parseOptions{})
+	if err != nil || response.Header.Opcode != InverseQuery {
+		t.Fatalf("Got: %v (%v), Want: IQUERY response parsed in non-strict mode", response, err)
+	}
+	_, err = parseResponseWithOptions(responseBytes, parseOptions{strict: true})
+	if err == nil {
+		t.Fatalf("Got no error, Want: IQUERY rejected in strict mode")
+	}
+}
+
+func TestParseComputesExpiresAt(t *testing.T) {
+	parsedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	record := aRecord("example.com", "93.184.216.34")
+	record.ttl = 3600
+	responseBytes := testReply{answers: []testRecord{record}}.bytesFor(request)
+	response, err := parseResponseWithOptions(responseBytes, parseOptions{now: func() time.Time {
+		return parsedAt
+	}})
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	want := parsedAt.Add(time.Hour)
+	if !response.Answers[0].ExpiresAt.Equal(want) {
+		t.Fatalf("Got: %v, Want: %v", response.Answers[0].ExpiresAt, want)
+	}
+}
+
+func TestAnswerBytesRoundTrip(t *testing.T) {
+	answers := []DnsAnswer{
+		{Domain: "example.com", RecordType: A, Address: "93.184.216.34"},
+		{Domain: "example.com", RecordType: AAAA, Address: "2606:2800:220:1::248"},
+		{Domain: "www.example.com", RecordType: CNAME, Target: "example.com"},
+		{Domain: "example.com", RecordType: NS, Target: "ns1.example.com"},
+		{Domain: "example.com", RecordType: MX, Mx: &MxData{Preference: 10, Exchange: "mail.example.com"}},
+		{Domain: "example.com", RecordType: TXT, Txt: []string{"v=spf1 -all", "hello world"}},
+		{Domain: "example.com", RecordType: HINFO, Hinfo: &HinfoData{Cpu: "AMD64", Os: "LINUX"}},
+		{Domain: "example.com", RecordType: CAA, Caa: &CaaData{Flags: 0, Tag: "issue", Value: "letsencrypt.org"}},
+	}
+	ctx := newCompressionContext(0)
+	var message []byte
+	for i := range answers {
+		answers[i].RecordClass = IN
+		answers[i].TTL = 300
+		answerBytes, err := answers[i].GetBytes(ctx)
+		if err != nil {
+			t.Fatalf("Error serializing record type %d: %v", answers[i].RecordType, err)
+		}
+		message = append(message, answerBytes...)
+	}
+	reader := bytereader.NewByteReader(message)
+	for _, want := range answers {
+		got, err := parseAnswersFromResponse(reader, parseOptions{})
+		if err != nil {
+			t.Fatalf("Error parsing record type %d: %v", want.RecordType, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Fatalf("Got: %+v, Want: %+v", *got, want)
+		}
+	}
+	if reader.GetAvailableBytes() != 0 {
+		t.Fatalf("Got %d unread bytes, Want: 0", reader.GetAvailableBytes())
+	}
+}
+
+func TestAnswerBytesCompressesNames(t *testing.T) {
+	ctx := newCompressionContext(12)
+	first, _ := DnsAnswer{Domain: "example.com", RecordType: A, RecordClass: IN, Address: "93.184.216.34"}.GetBytes(ctx)
+	second, _ := DnsAnswer{Domain: "www.example.com", RecordType: CNAME, RecordClass: IN, Target: "example.com"}.GetBytes(ctx)
+	if !slices.Equal(second[:6], []byte{3, 'w', 'w', 'w', 0xc0, 0x0c}) {
+		t.Fatalf("Got: %s, Want: www followed by a pointer to offset 12", hex.EncodeToString(second[:6]))
+	}
+	if !slices.Equal(second[len(second)-4:], []byte{0, 2, 0xc0, 0x0c}) {
+		t.Fatalf("Got: %s, Want: target written as a pointer to offset 12", hex.EncodeToString(second[len(second)-4:]))
+	}
+	if ctx.length != 12+len(first)+len(second) {
+		t.Fatalf("Got length: %d, Want: %d", ctx.length, 12+len(first)+len(second))
+	}
+}
+
+func TestResponseBytesKeepCaseOfNames(t *testing.T) {
+	response := buildErrorResponse(generateDnsQuery("example.com", CNAME), NoError)
+	response.Answers = []DnsAnswer{
+		{Domain: "WWW.Example.com", RecordType: CNAME, RecordClass: IN, TTL: 300, Target: "CDN.EXAMPLE.COM"},
+		{Domain: "cdn.example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "192.0.2.1"},
+	}
+	responseBytes, err := response.GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing response: %v", err)
+	}
+	parsed, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if diff := responseDiff(parsed, response); diff != "" {
+		t.Fatalf("Got differences after serializing the response:\n%s", diff)
+	}
+}
+
+func TestAnswerBytesRejectsInvalidAddress(t *testing.T) {
+	_, err := DnsAnswer{Domain: "example.com", RecordType: A, Address: "2606:2800:220:1::248"}.GetBytes(nil)
+	if err == nil {
+		t.Fatalf("Got no error, Want: IPv6 address rejected for an A record")
+	}
+}
+
+// responseDiff describes how got differs from want, one line per mismatch, or returns "" when
+// they are the same response. It leaves out what changes from one exchange or parse to the
+// next: the message ID, the record counts of the header, the server that answered, the raw
+// bytes and the expiry times and sections of records, which ParseResponse fills in.
+func responseDiff(got, want *DnsResponse) string {
+	if got == nil || want == nil {
+		if got == want {
+			return ""
+		}
+		return fmt.Sprintf("response: got %v, want %v", got, want)
+	}
+	var diffs []string
+	gotHeader, wantHeader := comparableHeader(got.Header), comparableHeader(want.Header)
+	if gotHeader != wantHeader {
+		diffs = append(diffs, fmt.Sprintf("header: got %+v, want %+v", gotHeader, wantHeader))
+	}
+	gotQuestions, wantQuestions := comparableQuestions(got), comparableQuestions(want)
+	if !reflect.DeepEqual(gotQuestions, wantQuestions) {
+		diffs = append(diffs, fmt.Sprintf("questions: got %+v, want %+v", gotQuestions, wantQuestions))
+	}
+	sections := []struct {
+		name      string
+		got, want []DnsAnswer
+	}{
+		{"answer", got.Answers, want.Answers},
+		{"authority", got.NameServerRecords, want.NameServerRecords},
+		{"additional", got.AdditionalRecords, want.AdditionalRecords},
+	}
+	for _, section := range sections {
+		if len(section.got) != len(section.want) {
+			diffs = append(diffs, fmt.Sprintf("%s section: got %d records, want %d", section.name, len(section.got), len(section.want)))
+			continue
+		}
+		for i := range section.got {
+			gotRecord, wantRecord := section.got[i], section.want[i]
+			gotRecord.ExpiresAt, wantRecord.ExpiresAt = time.Time{}, time.Time{}
+			gotRecord.Section, wantRecord.Section = 0, 0
+			if reflect.DeepEqual(gotRecord, wantRecord) {
+				continue
+			}
+			gotText, wantText := gotRecord.Presentation(), wantRecord.Presentation()
+			if gotText == wantText {
+				gotText, wantText = fmt.Sprintf("%+v", gotRecord), fmt.Sprintf("%+v", wantRecord)
+			}
+			diffs = append(diffs, fmt.Sprintf("%s record %d: got %s, want %s", section.name, i, gotText, wantText))
+		}
+	}
+	if got.Validated != want.Validated {
+		diffs = append(diffs, fmt.Sprintf("validated: got %t, want %t", got.Validated, want.Validated))
+	}
+	return strings.Join(diffs, "\n")
+}
+
+// responsesEqual reports whether got and want are the same response as far as responseDiff
+// is concerned.
+func responsesEqual(got, want *DnsResponse) bool {
+	return responseDiff(got, want) == ""
+}
+
+func comparableHeader(header *DnsHeader) DnsHeader {
+	if header == nil {
+		return DnsHeader{}
+	}
+	stripped := *header
+	stripped.Id = 0
+	stripped.QuestionCount = 0
+	stripped.AnswerCount = 0
+	stripped.NameServerRecordsCount = 0
+	stripped.AdditionalRecordsCount = 0
+	return stripped
+}
+
+// comparableQuestions returns the questions of r the way GetBytes writes them.
+func comparableQuestions(r *DnsResponse) []DnsQueryQuestion {
+	if len(r.Questions) == 0 && r.Question != nil {
+		return []DnsQueryQuestion{*r.Question}
+	}
+	return r.Questions
+}
+
+func TestResponseDiff(t *testing.T) {
+	want := benchmarkResponse()
+	responseBytes, err := want.GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing response: %v", err)
+	}
+	got, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	got.Header.Id++
+	got.Server = "192.0.2.53:53"
+	if diff := responseDiff(got, want); diff != "" {
+		t.Fatalf("Got differences for a round trip:\n%s", diff)
+	}
+	if !responsesEqual(nil, nil) || responsesEqual(got, nil) {
+		t.Fatalf("Want: nil only equal to nil")
+	}
+
+	got.Header.IsAuthoritativeAnswer = true
+	got.Answers[1].Address = "192.0.2.9"
+	got.Answers[2].Target = "stray.example.com"
+	got.AdditionalRecords = got.AdditionalRecords[:1]
+	wantDiffs := []string{
+		"header: got",
+		"answer record 1: got cdn.example.com. 60 IN A 192.0.2.9, want cdn.example.com. 60 IN A 192.0.2.1",
+		"answer record 2: got {",
+		"additional section: got 1 records, want 2",
+	}
+	diffs := strings.Split(responseDiff(got, want), "\n")
+	if len(diffs) != len(wantDiffs) {
+		t.Fatalf("Got differences:\n%s\nWant: %d of them", strings.Join(diffs, "\n"), len(wantDiffs))
+	}
+	for i, diff := range diffs {
+		if !strings.HasPrefix(diff, wantDiffs[i]) {
+			t.Fatalf("Got: %q, Want: a line starting with %q", diff, wantDiffs[i])
+		}
+	}
+	if responsesEqual(got, want) {
+		t.Fatalf("Got: equal, Want: different responses")
+	}
+}
+
+func TestAnswerBytesRejectsInvalidNames(t *testing.T) {
+	longLabel := strings.Repeat("a", 64) + ".example.com"
+	longName := strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com"
+	answers := []DnsAnswer{
+		{Domain: longLabel, RecordType: A, Address: "192.0.2.1"},
+		{Domain: longName, RecordType: A, Address: "192.0.2.1"},
+		{Domain: "example.com", RecordType: CNAME, Target: "www..example.com"},
+		{Domain: "example.com", RecordType: MX, Mx: &MxData{Preference: 10, Exchange: longLabel}},
+		{Domain: "example.com", RecordType: RP, Rp: &RpData{Mbox: "admin.example.com", Txt: longName}},
+	}
+	for _, answer := range answers {
+		_, err := answer.GetBytes(newCompressionContext(0))
+		if err == nil {
+			t.Fatalf("Got no error, Want: invalid name rejected in %+v", answer)
+		}
+	}
+	response := buildErrorResponse(generateDnsQuery("example.com", A), NoError)
+	response.Question.Qname = append([]byte{64}, append([]byte(strings.Repeat("a", 64)), 0)...)
+	response.Questions = nil
+	if _, err := response.GetBytes(); err == nil {
+		t.Fatalf("Got no error, Want: question with a 64 byte label rejected")
+	}
+}
+
+func TestErrorResponseRoundTrip(t *testing.T) {
+	query := generateDnsQuery("nonexistent.example.com", AAAA)
+	query.Header.IsRecursionDesired = true
+	responseBytes, err := buildErrorResponse(query, NameError).GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing response: %v", err)
+	}
+	if !isResponseToQuery(query, responseBytes) {
+		t.Fatalf("Response %s does not answer the query", hex.EncodeToString(responseBytes))
+	}
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	header := response.Header
+	if header.Id != query.Header.Id || !header.IsResponse || !header.IsRecursionDesired || header.ResponseCode != NameError {
+		t.Fatalf("Got header: %+v, Want: NXDOMAIN response to query %d", *header, query.Header.Id)
+	}
+	if header.QuestionCount != 1 || header.AnswerCount != 0 || len(response.Answers) != 0 {
+		t.Fatalf("Got header: %+v, Want: one question and no answers", *header)
+	}
+	if response.Question.GetDomainName() != "nonexistent.example.com" || response.Question.Qtype != AAAA {
+		t.Fatalf("Got question: %s (%d), Want: nonexistent.example.com (%d)",
+			response.Question.GetDomainName(), response.Question.Qtype, AAAA)
+	}
+}
+
+func TestForwardedResponseClearsUnvalidatedAuthenticData(t *testing.T) {
+	upstreamQuery := generateDnsQuery("www.example.com", A)
+	upstreamBytes := testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(
+		&DnsResponse{Header: &upstreamQuery.Header, Question: &upstreamQuery.Questions[0]})
+	upstreamBytes[3] |= 0x20
+	upstream, err := ParseResponse(upstreamBytes)
+	if err != nil {
+		t.Fatalf("Error parsing upstream response: %v", err)
+	}
+	if !upstream.Header.IsAuthenticData {
+		t.Fatalf("Got header: %+v, Want: AD bit parsed from the upstream response", *upstream.Header)
+	}
+	clientQuery := generateDnsQuery("www.example.com", A)
+	clientQuery.Header.IsRecursionDesired = true
+	for _, validated := range []bool{false, true} {
+		upstream.Validated = validated
+		responseBytes, err := NewForwardedResponse(clientQuery, upstream).GetBytes()
+		if err != nil {
+			t.Fatalf("Error serializing forwarded response: %v", err)
+		}
+		if !isResponseToQuery(clientQuery, responseBytes) {
+			t.Fatalf("Forwarded response %s does not answer the client query", hex.EncodeToString(responseBytes))
+		}
+		response, err := ParseResponse(responseBytes)
+		if err != nil {
+			t.Fatalf("Error parsing forwarded response: %v", err)
+		}
+		if response.Header.IsAuthenticData != validated {
+			t.Fatalf("Got AD bit: %t, Want: %t for a response validated: %t", response.Header.IsAuthenticData, validated, validated)
+		}
+		if !response.Header.IsRecursionDesired || len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+			t.Fatalf("Got: %+v with answers %+v, Want: the upstream answer for the client", *response.Header, response.Answers)
+		}
+	}
+	if !upstream.Header.IsAuthenticData || upstream.Header.Id != upstreamQuery.Header.Id {
+		t.Fatalf("Got upstream header: %+v, Want: left untouched", *upstream.Header)
+	}
+}
+
+func TestForwardedNegativeResponseKeepsSoa(t *testing.T) {
+	// The SOA names point to example.com in the upstream question, at offset 24.
+	soaRdata := []byte{3, 'n', 's', '1', 0xc0, 0x18, 10, 'h', 'o', 's', 't', 'm', 'a', 's', 't', 'e', 'r', 0xc0, 0x18}
+	soaRdata = append(soaRdata, testSoaRdata()[19:]...)
+	upstreamQuery := generateDnsQuery("nonexistent.example.com", A)
+	upstreamBytes := testReply{
+		rcode:       NameError,
+		authorities: []testRecord{{name: "example.com", rtype: SOA, ttl: 300, rdata: soaRdata}},
+	}.bytesFor(&DnsResponse{Header: &upstreamQuery.Header, Question: &upstreamQuery.Questions[0]})
+	upstream, err := ParseResponse(upstreamBytes)
+	if err != nil {
+		t.Fatalf("Error parsing upstream response: %v", err)
+	}
+	clientQuery := generateDnsQuery("a.b.c.d.nonexistent.example.com", A)
+	responseBytes, err := NewForwardedResponse(clientQuery, upstream).GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing forwarded response: %v", err)
+	}
+	if !isResponseToQuery(clientQuery, responseBytes) {
+		t.Fatalf("Forwarded response %s does not answer the client query", hex.EncodeToString(responseBytes))
+	}
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing forwarded response: %v", err)
+	}
+	want := SoaData{Mname: "ns1.example.com", Rname: "hostmaster.example.com", Serial: 2018074369,
+		Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 3600}
+	if len(response.NameServerRecords) != 1 || response.NameServerRecords[0].Soa == nil || *response.NameServerRecords[0].Soa != want {
+		t.Fatalf("Got authority records: %+v, Want: SOA %+v", response.NameServerRecords, want)
+	}
+}
+
+func TestForwardedResponseKeepsDnssecRecords(t *testing.T) {
+	answers := []testRecord{
+		aRecord("www.example.com", "93.184.216.34"),
+		{name: "www.example.com", rtype: RRSIG, ttl: 300, rdata: testRrsigRdata(getDomainNameInQnameFormat("example.com"))},
+	}
+	authorities := []testRecord{
+		{name: "example.com", rtype: NSEC, ttl: 300, rdata: append(getDomainNameInQnameFormat("www.example.com"), 0, 1, 0x40)},
+		{name: "example.com", rtype: DS, ttl: 300, rdata: []byte{0x30, 0x39, 13, 2, 0x2b, 0xb1, 0x83, 0xaf}},
+	}
+	additionals := []testRecord{{name: "example.com", rtype: DNSKEY, ttl: 300, rdata: []byte{0x01, 0x01, 3, 13, 0x99, 0xdb}}}
+	upstreamQuery := generateDnsQuery("www.example.com", A)
+	upstreamBytes := testReply{answers: answers, authorities: authorities, additionals: additionals}.bytesFor(
+		&DnsResponse{Header: &upstreamQuery.Header, Question: &upstreamQuery.Questions[0]})
+	upstream, err := ParseResponse(upstreamBytes)
+	if err != nil {
+		t.Fatalf("Error parsing upstream response: %v", err)
+	}
+	upstream.Validated = true
+	responseBytes, err := NewForwardedResponse(generateDnsQuery("www.example.com", A), upstream).GetBytes()
+	if err != nil {
+		t.Fatalf("Error serializing forwarded response: %v", err)
+	}
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing forwarded response: %v", err)
+	}
+	sections := []struct {
+		got  []DnsAnswer
+		want []testRecord
+	}{
+		{response.Answers, answers},
+		{response.NameServerRecords, authorities},
+		{response.AdditionalRecords, additionals},
+	}
+	for _, section := range sections {
+		if len(section.got) != len(section.want) {
+			t.Fatalf("Got records: %v, Want: %d records", section.got, len(section.want))
+		}
+		for i, record := range section.got {
+			recordBytes, err := record.GetBytes(nil)
+			if err != nil || !bytes.Equal(recordBytes, section.want[i].getBytes()) {
+				t.Fatalf("Got: %x (%v), Want: %x for the forwarded %s record", recordBytes, err, section.want[i].getBytes(), record.RecordType)
+			}
+		}
+	}
+}
+
+func TestParseCharacterStringRecords(t *testing.T) {
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: TXT, ttl: 300, rdata: []byte{2, 'h', 'i', 0, 3, 'f', 'o', 'o'}},
+		testRecord{name: "example.com", rtype: HINFO, ttl: 300, rdata: []byte{5, 'A', 'M', 'D', '6', '4', 5, 'L', 'I', 'N', 'U', 'X'}},
+		testRecord{name: "example.com", rtype: CAA, ttl: 300, rdata: append([]byte{128, 5, 'i', 's', 's', 'u', 'e'}, "letsencrypt.org"...)},
+	)
+	if !slices.Equal(answers[0].Txt, []string{"hi", "", "foo"}) {
+		t.Fatalf("Got TXT: %q, Want: [hi  foo]", answers[0].Txt)
+	}
+	if answers[1].Hinfo == nil || *answers[1].Hinfo != (HinfoData{Cpu: "AMD64", Os: "LINUX"}) {
+		t.Fatalf("Got HINFO: %+v, Want: AMD64 LINUX", answers[1].Hinfo)
+	}
+	if answers[2].Caa == nil || *answers[2].Caa != (CaaData{Flags: 128, Tag: "issue", Value: "letsencrypt.org"}) {
+		t.Fatalf("Got CAA: %+v, Want: 128 issue letsencrypt.org", answers[2].Caa)
+	}
+}
+
+func TestParseTxtRejectsStringBeyondRecordData(t *testing.T) {
+	query := generateDnsQuery("example.com", TXT)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	txt := testRecord{name: "example.com", rtype: TXT, ttl: 300, rdata: []byte{2, 'h', 'i', 5, 'o'}}
+	responseBytes := testReply{answers: []testRecord{txt, aRecord("example.com", "93.184.216.34")}}.bytesFor(request)
+	_, err := ParseResponse(responseBytes)
+	if err == nil {
+		t.Fatalf("Got no error, Want: TXT string crossing the record data rejected")
+	}
+}
+
+func TestParseSpfRecordLikeTxt(t *testing.T) {
+	rdata := []byte{11, 'v', '=', 's', 'p', 'f', '1', ' ', '-', 'a', 'l', 'l'}
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: SPF, ttl: 300, rdata: rdata},
+		testRecord{name: "example.com", rtype: TXT, ttl: 300, rdata: rdata},
+	)
+	if !slices.Equal(answers[0].Txt, []string{"v=spf1 -all"}) || !slices.Equal(answers[0].Txt, answers[1].Txt) {
+		t.Fatalf("Got SPF: %q and TXT: %q, Want: both [v=spf1 -all]", answers[0].Txt, answers[1].Txt)
+	}
+}
+
+func TestParseAplRecord(t *testing.T) {
+	rdata := []byte{0, 1, 24, 0x83, 192, 168, 32, 0, 2, 0, 0}
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: APL, ttl: 300, rdata: rdata})
+	want := &AplData{Prefixes: []AplPrefix{
+		{Family: 1, PrefixLength: 24, Negation: true, AfdLength: 3, AfdPart: []byte{192, 168, 32}},
+		{Family: 2, PrefixLength: 0, Negation: false, AfdLength: 0, AfdPart: []byte{}},
+	}}
+	if !reflect.DeepEqual(answers[0].Apl, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Apl, want)
+	}
+}
+
+// TestParsedRecordsRoundTrip parses each record, serializes it and parses it again, which must
+// give back the same record data and the same record.
+func TestParsedRecordsRoundTrip(t *testing.T) {
+	records := []testRecord{
+		{name: "example.com", rtype: APL, ttl: 300, rdata: []byte{0, 1, 24, 0x83, 192, 168, 32, 0, 2, 0, 0}},
+		{name: "example.com", rtype: SOA, ttl: 300, rdata: append(append(getDomainNameInQnameFormat("ns1.example.com"),
+			getDomainNameInQnameFormat("hostmaster.example.com")...), testSoaRdata()[19:]...)},
+		{name: "34.216.184.93.in-addr.arpa", rtype: PTR, ttl: 300, rdata: getDomainNameInQnameFormat("www.example.com")},
+		// An IPv4-mapped address, which must not be mistaken for the address of an A record.
+		{name: "example.com", rtype: AAAA, ttl: 300, rdata: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 0, 2, 1}},
+		{name: "example.com", rtype: NSEC, ttl: 300, rdata: append(getDomainNameInQnameFormat("host.example.com"), 0, 4, 0x40, 0x01, 0x00, 0x08, 1, 1, 0x40)},
+		{name: "example.com", rtype: RRSIG, ttl: 300, rdata: testRrsigRdata(getDomainNameInQnameFormat("example.com"))},
+		{name: "example.com", rtype: DNSKEY, ttl: 300, rdata: []byte{0x01, 0x01, 3, 13, 0x99, 0xdb, 0x2c, 0xc1}},
+		{name: "example.com", rtype: DS, ttl: 300, rdata: []byte{0x30, 0x39, 13, 2, 0x2b, 0xb1, 0x83, 0xaf, 0x5f, 0x22, 0x58, 0x81}},
+		{name: "example.com", rtype: LOC, ttl: 300, rdata: testLocRdata()},
+		// The largest coordinates and altitude the wire format holds, far beyond the poles.
+		{name: "example.com", rtype: LOC, ttl: 300, rdata: []byte{0, 0x99, 0, 0x12, 255, 255, 255, 255, 0, 0, 0, 0, 255, 255, 255, 255}},
+		{name: "example.com", rtype: NAPTR, ttl: 300, rdata: testNaptrRdata()},
+		{name: "_443._tcp.example.com", rtype: TLSA, ttl: 300, rdata: []byte{3, 1, 1, 0x0d, 0x6f, 0xce, 0x33}},
+		{name: "example.com", rtype: HTTPS, ttl: 300, rdata: testHttpsRdata(getDomainNameInQnameFormat("svc.example.com"))},
+		// Parameters out of order, which must be written back in the same order.
+		{name: "example.com", rtype: SVCB, ttl: 300, rdata: []byte{0, 1, 0, 0, 3, 0, 2, 0x20, 0xfb, 0, 1, 0, 3, 2, 'h', '2'}},
+		{name: "example.com", rtype: CERT, ttl: 300, rdata: []byte{0, 1, 0x30, 0x39, 8, 0x30, 0x82, 0x01, 0x0a}},
+		{name: "host.example.com", rtype: SSHFP, ttl: 300, rdata: []byte{4, 2, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf6}},
+	}
+	for _, record := range records {
+		parsed := parseTestRecords(t, record)[0]
+		recordBytes, err := parsed.GetBytes(nil)
+		if err != nil {
+			t.Fatalf("Error serializing record type %s: %v", record.rtype, err)
+		}
+		if !bytes.Equal(recordBytes, record.getBytes()) {
+			t.Fatalf("Got: %x, Want: %x for record type %s", recordBytes, record.getBytes(), record.rtype)
+		}
+		reparsed, err := parseAnswersFromResponse(bytereader.NewByteReader(recordBytes), parseOptions{})
+		if err != nil {
+			t.Fatalf("Error parsing record type %s again: %v", record.rtype, err)
+		}
+		parsed.ExpiresAt, parsed.Section = time.Time{}, 0
+		reparsed.ExpiresAt, reparsed.Section = time.Time{}, 0
+		if !reflect.DeepEqual(*reparsed, parsed) {
+			t.Fatalf("Got: %+v, Want: %+v", *reparsed, parsed)
+		}
+	}
+}
+
+func TestIsValidHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"www.example.com", true},
+		{"www.example.com.", true},
+		{"3com.example-site.org", true},
+		{"localhost", true},
+		{strings.Repeat("a", 63) + ".com", true},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("abcdefghi.", 26) + "com", false},
+		{"www.exa_mple.com", false},
+		{"www.exam ple.com", false},
+		{"-www.example.com", false},
+		{"www-.example.com", false},
+		{"www..example.com", false},
+		{"", false},
+		{".", false},
+	}
+	for _, test := range tests {
+		if got := IsValidHostname(test.hostname); got != test.want {
+			t.Fatalf("Got: %t, Want: %t for %q", got, test.want, test.hostname)
+		}
+	}
+}
+
+func TestParseTagsRecordsWithSection(t *testing.T) {
+	query := generateDnsQuery("www.example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	responseBytes := testReply{
+		answers:     []testRecord{aRecord("www.example.com", "93.184.216.34")},
+		authorities: []testRecord{nsRecord("example.com", "ns1.example.com"), nsRecord("example.com", "ns2.example.com")},
+		additionals: []testRecord{aRecord("ns1.example.com", "192.0.2.1")},
+	}.bytesFor(request)
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	sections := map[Section][]DnsAnswer{
+		AnswerSection:     response.Answers,
+		AuthoritySection:  response.NameServerRecords,
+		AdditionalSection: response.AdditionalRecords,
+	}
+	for section, records := range sections {
+		for _, record := range records {
+			if record.Section != section {
+				t.Fatalf("Got section: %d, Want: %d for %+v", record.Section, section, record)
+			}
+		}
+	}
+}
+
+func TestParseNsecRecord(t *testing.T) {
+	rdata := getDomainNameInQnameFormat("host.example.com")
+	// Window 0 with A (1), MX (15) and AAAA (28), window 1 with CAA (257).
+	rdata = append(rdata, 0, 4, 0x40, 0x01, 0x00, 0x08, 1, 1, 0x40)
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: NSEC, ttl: 300, rdata: rdata},
+		aRecord("example.com", "93.184.216.34"),
+	)
+	want := &NsecData{NextDomain: "host.example.com", Types: []MessageType{A, MX, AAAA, CAA}}
+	if !reflect.DeepEqual(answers[0].Nsec, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Nsec, want)
+	}
+	// The next domain name must not be compressed (RFC 4034 section 4.1.1).
+	compressed := testRecord{name: "example.com", rtype: NSEC, ttl: 300, rdata: []byte{4, 'h', 'o', 's', 't', 0xc0, 0x0c, 0, 1, 0x40}}
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	_, err := ParseResponse(testReply{answers: []testRecord{compressed}}.bytesFor(request))
+	if err == nil {
+		t.Fatalf("Got no error, Want: compressed next domain name rejected")
+	}
+	for _, bitmap := range [][]byte{{1, 1, 0x40, 0, 1, 0x40}, {0, 1, 0x40, 0, 1, 0x20}} {
+		unordered := testRecord{name: "example.com", rtype: NSEC, ttl: 300, rdata: append([]byte{0}, bitmap...)}
+		_, err = ParseResponse(testReply{answers: []testRecord{unordered}}.bytesFor(request))
+		if err == nil {
+			t.Fatalf("Got no error, Want: type bitmap %x with windows out of order rejected", bitmap)
+		}
+	}
+}
+
+func TestParseRrsigRecord(t *testing.T) {
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: RRSIG, ttl: 300, rdata: testRrsigRdata(getDomainNameInQnameFormat("example.com"))})
+	want := &RrsigData{
+		TypeCovered: A,
+		Algorithm:   13,
+		Labels:      2,
+		OriginalTTL: 3600,
+		Expiration:  0x66000000,
+		Inception:   0x65000000,
+		KeyTag:      12345,
+		SignerName:  "example.com",
+		Signature:   []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	if !reflect.DeepEqual(answers[0].Rrsig, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Rrsig, want)
+	}
+	// The signer name must not be compressed (RFC 4034 section 3.1.7).
+	compressed := testRecord{name: "example.com", rtype: RRSIG, ttl: 300, rdata: testRrsigRdata([]byte{0xc0, 0x0c})}
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	_, err := ParseResponse(testReply{answers: []testRecord{compressed}}.bytesFor(request))
+	if err == nil {
+		t.Fatalf("Got no error, Want: compressed signer name rejected")
+	}
+}
+
+// testRrsigRdata returns the data of an RRSIG record covering A records with signerName in
+// wire format.
+func testRrsigRdata(signerName []byte) []byte {
+	rdata := []byte{0, 1, 13, 2, 0, 0, 0x0e, 0x10, 0x66, 0x00, 0x00, 0x00, 0x65, 0x00, 0x00, 0x00, 0x30, 0x39}
+	rdata = append(rdata, signerName...)
+	return append(rdata, 0xde, 0xad, 0xbe, 0xef)
+}
+
+func TestParseDnskeyRecord(t *testing.T) {
+	rdata := []byte{0x01, 0x01, 3, 13, 0x99, 0xdb, 0x2c, 0xc1}
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: DNSKEY, ttl: 300, rdata: rdata})
+	want := &DnskeyData{Flags: 257, Protocol: 3, Algorithm: 13, PublicKey: []byte{0x99, 0xdb, 0x2c, 0xc1}}
+	if !reflect.DeepEqual(answers[0].Dnskey, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Dnskey, want)
+	}
+}
+
+func TestParseDsRecord(t *testing.T) {
+	digest := make([]byte, 32)
+	for i := range digest {
+		digest[i] = byte(i)
+	}
+	rdata := append([]byte{0x30, 0x39, 13, 2}, digest...)
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: DS, ttl: 300, rdata: rdata},
+		aRecord("example.com", "93.184.216.34"),
+	)
+	want := &DsData{KeyTag: 12345, Algorithm: 13, DigestType: 2, Digest: digest}
+	if !reflect.DeepEqual(answers[0].Ds, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Ds, want)
+	}
+	if len(answers[0].Ds.Digest) != len(rdata)-4 {
+		t.Fatalf("Got digest length: %d, Want: %d", len(answers[0].Ds.Digest), len(rdata)-4)
+	}
+}
+
+func TestParseSshfpRecord(t *testing.T) {
+	fingerprint, _ := hex.DecodeString("123456789abcdef67890123456789abcdef67890")
+	answers := parseTestRecords(t,
+		testRecord{name: "host.example.com", rtype: SSHFP, ttl: 300, rdata: append([]byte{4, 1}, fingerprint...)},
+		aRecord("host.example.com", "192.0.2.22"),
+	)
+	want := SshfpData{Algorithm: 4, FingerprintType: 1, Fingerprint: "123456789abcdef67890123456789abcdef67890"}
+	if answers[0].Sshfp == nil || *answers[0].Sshfp != want {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Sshfp, want)
+	}
+	if got := answers[0].RdataPresentation(); got != "4 1 123456789abcdef67890123456789abcdef67890" {
+		t.Fatalf("Got: %s, Want: 4 1 123456789abcdef67890123456789abcdef67890", got)
+	}
+	invalid := DnsAnswer{Domain: "host.example.com", RecordType: SSHFP, Sshfp: &SshfpData{Algorithm: 4, FingerprintType: 1, Fingerprint: "xyz"}}
+	if _, err := invalid.GetBytes(nil); err == nil {
+		t.Fatalf("Got no error, Want: fingerprint that isn't hex rejected")
+	}
+}
+
+func TestParseCertRecord(t *testing.T) {
+	certificate := []byte{0x30, 0x82, 0x01, 0x0a, 0x02, 0x82, 0x01, 0x01}
+	rdata := append([]byte{0, 1, 0x30, 0x39, 8}, certificate...)
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: CERT, ttl: 300, rdata: rdata},
+		aRecord("example.com", "93.184.216.34"),
+	)
+	want := &CertData{Type: 1, KeyTag: 12345, Algorithm: 8, Certificate: certificate}
+	if !reflect.DeepEqual(answers[0].Cert, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Cert, want)
+	}
+	if got := answers[0].RdataPresentation(); got != "1 12345 8 MIIBCgKCAQE=" {
+		t.Fatalf("Got: %s, Want: 1 12345 8 MIIBCgKCAQE=", got)
+	}
+}
+
+func TestParseDecodesRecordsByTypeInAnySection(t *testing.T) {
+	query := generateDnsQuery("www.example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	responseBytes := testReply{
+		authorities: []testRecord{aRecord("www.example.com", "192.0.2.1"), nsRecord("example.com", "ns1.example.com")},
+		additionals: []testRecord{nsRecord("example.com", "ns2.example.com")},
+	}.bytesFor(request)
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	authorities := response.NameServerRecords
+	if len(authorities) != 2 || authorities[0].RecordType != A || authorities[0].Address != "192.0.2.1" ||
+		authorities[0].Section != AuthoritySection {
+		t.Fatalf("Got authority records: %+v, Want: the A record decoded as one", authorities)
+	}
+	additionals := response.AdditionalRecords
+	if len(additionals) != 1 || additionals[0].Target != "ns2.example.com" || additionals[0].Section != AdditionalSection {
+		t.Fatalf("Got additional records: %+v, Want: the NS record decoded as one", additionals)
+	}
+	zone, nameServers := findReferral(response, "www.example.com", "")
+	if zone != "example.com" || !slices.Equal(nameServers, []string{"ns1.example.com"}) {
+		t.Fatalf("Got referral to %q via %v, Want: example.com via ns1.example.com only", zone, nameServers)
+	}
+}
+
+func TestParseTsigRecordInAdditionalSection(t *testing.T) {
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	// Algorithm name, time signed, fudge, MAC size and MAC, original ID, error and other length.
+	rdata := getDomainNameInQnameFormat("hmac-sha256")
+	rdata = append(rdata, 0, 0, 0x65, 0x92, 0x00, 0x00, 0x01, 0x2c, 0, 4, 0xde, 0xad, 0xbe, 0xef)
+	rdata = append(rdata, byte(query.Header.Id>>8), byte(query.Header.Id), 0, 0, 0, 0)
+	tsig := testRecord{name: "key.example.com", rtype: TSIG, rdata: rdata}
+	responseBytes := testReply{
+		answers:     []testRecord{aRecord("example.com", "93.184.216.34")},
+		additionals: []testRecord{tsig},
+	}.bytesFor(request)
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Error parsing response: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: the A record", response.Answers)
+	}
+	additional := response.AdditionalRecords
+	if len(additional) != 1 || additional[0].RecordType != TSIG || additional[0].Domain != "key.example.com" {
+		t.Fatalf("Got additional records: %v, Want: the TSIG record", additional)
+	}
+	if !bytes.Equal(additional[0].RawData, rdata) {
+		t.Fatalf("Got record data: %x, Want: %x", additional[0].RawData, rdata)
+	}
+	if additional[0].RecordType.String() != "TSIG" || MessageType(TKEY).String() != "TKEY" {
+		t.Fatalf("Got type names %s and %s, Want: TSIG and TKEY", additional[0].RecordType, MessageType(TKEY))
+	}
+}
+
+func TestParseRejectsInflatedRecordCount(t *testing.T) {
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	responseBytes := testReply{answers: []testRecord{aRecord("example.com", "93.184.216.34")}}.bytesFor(request)
+	responseBytes[6], responseBytes[7] = 0xff, 0xff
+	_, err := ParseResponse(responseBytes)
+	if err == nil || !strings.Contains(err.Error(), "65535 records") {
+		t.Fatalf("Got error: %v, Want: record count inconsistent with the buffer", err)
+	}
+}
+
+func TestParseCompressedQuestionName(t *testing.T) {
+	header := DnsHeader{Id: 0x1234, IsResponse: true, QuestionCount: 1}
+	response := header.GetBytes()
+	// The name points at the zero answer count in the header, which reads as the root.
+	response = append(response, 3, 'w', 'w', 'w', 0xc0, 0x06, 0, 1, 0, 1)
+	parsed, err := ParseResponse(response)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := parsed.Question.GetDomainName(); got != "www" {
+		t.Fatalf("Got: %s, Want: www", got)
+	}
+	if parsed.Question.Qtype != A || parsed.Question.Qclass != IN {
+		t.Fatalf("Got type %d and class %d, Want: A and IN", parsed.Question.Qtype, parsed.Question.Qclass)
+	}
+}
+
+func TestParseResponseWithTwoQuestions(t *testing.T) {
+	header := DnsHeader{Id: 0x1234, IsResponse: true, QuestionCount: 2, AnswerCount: 1}
+	response := header.GetBytes()
+	response = append(response, DnsQueryQuestion{Qname: getDomainNameInQnameFormat("example.com"), Qtype: A, Qclass: IN}.GetBytes()...)
+	response = append(response, DnsQueryQuestion{Qname: getDomainNameInQnameFormat("example.org"), Qtype: AAAA, Qclass: IN}.GetBytes()...)
+	response = append(response, aRecord("example.com", "93.184.216.34").getBytes()...)
+	parsed, err := ParseResponse(response)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(parsed.Questions) != 2 || parsed.Questions[1].GetDomainName() != "example.org" || parsed.Questions[1].Qtype != AAAA {
+		t.Fatalf("Got questions: %v, Want: example.com A and example.org AAAA", parsed.Questions)
+	}
+	if parsed.Question.GetDomainName() != "example.com" {
+		t.Fatalf("Got first question: %s, Want: example.com", parsed.Question.GetDomainName())
+	}
+	if len(parsed.Answers) != 1 || parsed.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34 after both questions", parsed.Answers)
+	}
+	serialized, err := parsed.GetBytes()
+	if err != nil || !bytes.Equal(serialized[:12], response[:12]) {
+		t.Fatalf("Got header: %x (%v), Want: %x with both questions", serialized[:12], err, response[:12])
+	}
+}
+
+func TestParseRejectsStandardResponseWithoutQuestion(t *testing.T) {
+	header := DnsHeader{Id: 0x1234, IsResponse: true}
+	_, err := ParseResponse(header.GetBytes())
+	if err == nil {
+		t.Fatalf("Got no error, Want: standard query response without question rejected")
+	}
+}
+
+func TestParseErrorResponseWithoutQuestion(t *testing.T) {
+	header := DnsHeader{Id: 0x1234, IsResponse: true, ResponseCode: FormatError, AdditionalRecordsCount: 1}
+	response := header.GetBytes()
+	response = append(response, aRecord("example.com", "93.184.216.34").getBytes()...)
+	parsed, err := ParseResponse(response)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if parsed.Question != nil || len(parsed.Questions) != 0 {
+		t.Fatalf("Got question: %v, Want: none", parsed.Question)
+	}
+	if len(parsed.AdditionalRecords) != 1 || parsed.AdditionalRecords[0].Address != "93.184.216.34" {
+		t.Fatalf("Got additional records: %v, Want: 93.184.216.34 right after the header", parsed.AdditionalRecords)
+	}
+}
+
+func TestResolveFormatErrorWithoutQuestion(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		header := DnsHeader{Id: query.Header.Id, IsResponse: true, ResponseCode: FormatError}
+		return header.GetBytes()
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Header.ResponseCode != FormatError || response.Question != nil {
+		t.Fatalf("Got rcode %d and question %v, Want: FORMERR without question", response.Header.ResponseCode, response.Question)
+	}
+}
+
+func TestMergeResponses(t *testing.T) {
+	ipv4 := buildErrorResponse(generateDnsQuery("example.com", A), NoError)
+	ipv4.Header.IsAuthoritativeAnswer = true
+	ipv4.Answers = []DnsAnswer{{Domain: "example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "93.184.216.34"}}
+	ipv4.Header.AnswerCount = 1
+	ipv6 := buildErrorResponse(generateDnsQuery("example.com", AAAA), NoError)
+	ipv6.Answers = []DnsAnswer{
+		{Domain: "example.com", RecordType: AAAA, RecordClass: IN, TTL: 300, Address: "2001:db8::1"},
+		{Domain: "example.com", RecordType: AAAA, RecordClass: IN, TTL: 300, Address: "2001:db8::2"},
+	}
+	merged := mergeResponses(ipv4, ipv6)
+	var got []string
+	for _, answer := range merged.Answers {
+		got = append(got, answer.Address)
+	}
+	if want := []string{"93.184.216.34", "2001:db8::1", "2001:db8::2"}; !slices.Equal(got, want) {
+		t.Fatalf("Got: %v, Want: %v", got, want)
+	}
+	if merged.Header.AnswerCount != 3 || !merged.Header.IsAuthoritativeAnswer || merged.Header.Id != ipv4.Header.Id {
+		t.Fatalf("Got header: %+v, Want: the header of the A response with 3 answers", merged.Header)
+	}
+	if ipv4.Header.AnswerCount != 1 || len(ipv4.Answers) != 1 {
+		t.Fatalf("Got %d answers in the A response, Want: it left unchanged", len(ipv4.Answers))
+	}
+}
+
+func TestParseLocRecord(t *testing.T) {
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: LOC, ttl: 300, rdata: testLocRdata()})
+	loc := answers[0].Loc
+	if loc == nil {
+		t.Fatalf("Got no LOC data")
+	}
+	got := []float64{loc.Latitude, loc.Longitude, loc.Altitude, loc.Size, loc.HorizontalPrecision, loc.VerticalPrecision}
+	want := []float64{42.365, -71.105, -24, 30, 10000, 10}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("Got: %v, Want: %v", got, want)
+		}
+	}
+	if got := answers[0].RdataPresentation(); got != "42 21 54.000 N 71 6 18.000 W -24m 30m 10000m 10m" {
+		t.Fatalf("Got: %s, Want: 42 21 54.000 N 71 6 18.000 W -24m 30m 10000m 10m", got)
+	}
+}
+
+// testLocRdata returns the data of the LOC record of cambridge-net.kei.com from RFC 1876:
+// 42 21 54 N 71 06 18 W -24m 30m.
+func testLocRdata() []byte {
+	rdata := []byte{0, 0x33, 0x16, 0x13}
+	rdata = append(rdata, utils.ConvertUint32ToBytesArray(2299997648)...)
+	rdata = append(rdata, utils.ConvertUint32ToBytesArray(1891505648)...)
+	return append(rdata, utils.ConvertUint32ToBytesArray(9997600)...)
+}
+
+func TestParseNaptrRecord(t *testing.T) {
+	answers := parseTestRecords(t,
+		testRecord{name: "example.com", rtype: NAPTR, ttl: 300, rdata: testNaptrRdata()},
+		aRecord("example.com", "93.184.216.34"),
+	)
+	want := &NaptrData{Order: 100, Preference: 10, Flags: "u", Service: "E2U+sip", Regexp: "!^.*$!sip:info@example.com!", Replacement: ""}
+	if !reflect.DeepEqual(answers[0].Naptr, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Naptr, want)
+	}
+	if got := answers[0].RdataPresentation(); got != `100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .` {
+		t.Fatalf(`Got: %s, Want: 100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`, got)
+	}
+}
+
+func testNaptrRdata() []byte {
+	rdata := []byte{0, 100, 0, 10}
+	for _, characterString := range []string{"u", "E2U+sip", "!^.*$!sip:info@example.com!"} {
+		rdata = append(rdata, byte(len(characterString)))
+		rdata = append(rdata, characterString...)
+	}
+	return append(rdata, 0)
+}
+
+func TestParseTlsaRecord(t *testing.T) {
+	digest := make([]byte, 32)
+	for i := range digest {
+		digest[i] = byte(255 - i)
+	}
+	rdata := append([]byte{3, 1, 1}, digest...)
+	answers := parseTestRecords(t, testRecord{name: "_443._tcp.example.com", rtype: TLSA, ttl: 300, rdata: rdata})
+	tlsa := answers[0].Tlsa
+	if tlsa == nil || tlsa.Usage != 3 || tlsa.Selector != 1 || tlsa.MatchingType != 1 {
+		t.Fatalf("Got: %+v, Want: usage 3, selector 1 and matching type 1", tlsa)
+	}
+	if len(tlsa.CertificateAssociationData) != len(rdata)-3 || !slices.Equal(tlsa.CertificateAssociationData, digest) {
+		t.Fatalf("Got data: %x, Want: %x", tlsa.CertificateAssociationData, digest)
+	}
+	if got := answers[0].RdataPresentation(); !strings.HasPrefix(got, "3 1 1 FFFEFDFC") || len(got) != len("3 1 1 ")+64 {
+		t.Fatalf("Got: %s, Want: 3 1 1 followed by the digest in hex", got)
+	}
+}
+
+func TestParseHttpsRecord(t *testing.T) {
+	answers := parseTestRecords(t, testRecord{name: "example.com", rtype: HTTPS, ttl: 300, rdata: testHttpsRdata(getDomainNameInQnameFormat("svc.example.com"))})
+	want := &SvcbData{
+		Priority: 1,
+		Target:   "svc.example.com",
+		Params: []SvcParam{
+			{Key: 1, Value: []byte{2, 'h', '2', 2, 'h', '3'}},
+			{Key: 3, Value: []byte{0x20, 0xfb}},
+			{Key: 4, Value: []byte{192, 0, 2, 1, 192, 0, 2, 2}},
+			{Key: 65000, Value: []byte{'a', 0}},
+		},
+	}
+	if !reflect.DeepEqual(answers[0].Svcb, want) {
+		t.Fatalf("Got: %+v, Want: %+v", answers[0].Svcb, want)
+	}
+	wantPresentation := `1 svc.example.com. alpn="h2,h3" port=8443 ipv4hint=192.0.2.1,192.0.2.2 key65000="a\000"`
+	if got := answers[0].RdataPresentation(); got != wantPresentation {
+		t.Fatalf("Got: %s, Want: %s", got, wantPresentation)
+	}
+	// The target name must not be compressed (RFC 9460 section 2.2).
+	compressed := testRecord{name: "example.com", rtype: HTTPS, ttl: 300, rdata: testHttpsRdata([]byte{3, 's', 'v', 'c', 0xc0, 0x0c})}
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	_, err := ParseResponse(testReply{answers: []testRecord{compressed}}.bytesFor(request))
+	if err == nil {
+		t.Fatalf("Got no error, Want: compressed target name rejected")
+	}
+}
+
+// testHttpsRdata returns the data of an HTTPS record with target, in wire format, and the
+// parameters alpn=h2,h3, port=8443, ipv4hint=192.0.2.1,192.0.2.2 and an unknown key.
+func testHttpsRdata(target []byte) []byte {
+	rdata := append([]byte{0, 1}, target...)
+	rdata = append(rdata, 0, 1, 0, 6, 2, 'h', '2', 2, 'h', '3')
+	rdata = append(rdata, 0, 3, 0, 2, 0x20, 0xfb)
+	rdata = append(rdata, 0, 4, 0, 8, 192, 0, 2, 1, 192, 0, 2, 2)
+	return append(rdata, 0xfd, 0xe8, 0, 2, 'a', 0)
+}
+
+func TestQueryHexDump(t *testing.T) {
+	query := generateDnsQuery("www.example.com", A)
+	query.Header.Id = 0x1234
+	want := "00000000  12 34 00 00 00 01 00 00  00 00 00 00 03 77 77 77  |.4...........www|\n" +
+		"00000010  07 65 78 61 6d 70 6c 65  03 63 6f 6d 00 00 01 00  |.example.com....|\n" +
+		"00000020  01                                                |.|\n"
+	if got := query.HexDump(); got != want {
+		t.Fatalf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+func TestResponseHexDumpShowsReceivedBytes(t *testing.T) {
+	query := generateDnsQuery("example.com", A)
+	request := &DnsResponse{Header: &query.Header, Question: &query.Questions[0]}
+	// Without compression the serialized response would differ from what was received.
+	responseBytes := testReply{answers: []testRecord{aRecord("example.com", "93.184.216.34")}}.bytesFor(request)
+	response, err := ParseResponse(responseBytes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := response.HexDump(), hex.Dump(responseBytes); got != want {
+		t.Fatalf("Got:\n%s\nWant:\n%s", got, want)
+	}
+}
+
+// BenchmarkParseResponseSameOwnerName parses a response whose 50 A records all point to the
+// owner name in the question, which is decoded once and then taken from the name cache.
+func BenchmarkParseResponseSameOwnerName(b *testing.B) {
+	header := DnsHeader{Id: 1, IsResponse: true, QuestionCount: 1, AnswerCount: 50}
+	response := header.GetBytes()
+	response = append(response, DnsQueryQuestion{Qname: getDomainNameInQnameFormat("a.long.owner.name.in.example.com"), Qtype: A, Qclass: IN}.GetBytes()...)
+	for i := 0; i < 50; i++ {
+		response = append(response, 0xc0, 0x0c, 0, byte(A), 0, byte(IN), 0, 0, 1, 44, 0, 4, 192, 0, 2, byte(i))
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := ParseResponse(response)
+		if err != nil {
+			b.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+// benchmarkResponse is a typical recursive answer: a CNAME chain to two addresses, with the
+// name servers of the zone and their addresses.
+func benchmarkResponse() *DnsResponse {
+	query := generateDnsQuery("www.example.com", A)
+	response := buildErrorResponse(query, NoError)
+	response.Answers = []DnsAnswer{
+		{Domain: "www.example.com", RecordType: CNAME, RecordClass: IN, TTL: 300, Target: "cdn.example.com"},
+		{Domain: "cdn.example.com", RecordType: A, RecordClass: IN, TTL: 60, Address: "192.0.2.1"},
+		{Domain: "cdn.example.com", RecordType: A, RecordClass: IN, TTL: 60, Address: "192.0.2.2"},
+	}
+	response.NameServerRecords = []DnsAnswer{
+		{Domain: "example.com", RecordType: NS, RecordClass: IN, TTL: 86400, Target: "ns1.example.com"},
+		{Domain: "example.com", RecordType: NS, RecordClass: IN, TTL: 86400, Target: "ns2.example.com"},
+	}
+	response.AdditionalRecords = []DnsAnswer{
+		{Domain: "ns1.example.com", RecordType: A, RecordClass: IN, TTL: 86400, Address: "198.51.100.1"},
+		{Domain: "ns2.example.com", RecordType: AAAA, RecordClass: IN, TTL: 86400, Address: "2001:db8::2"},
+	}
+	return response
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	b.Run("query", func(b *testing.B) {
+		query := generateDnsQuery("www.example.com", A)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = query.GetBytes()
+		}
+	})
+	b.Run("response", func(b *testing.B) {
+		response := benchmarkResponse()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := response.GetBytes()
+			if err != nil {
+				b.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkParseResponse(b *testing.B) {
+	response, err := benchmarkResponse().GetBytes()
+	if err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := ParseResponse(response)
+		if err != nil {
+			b.Fatalf("Unexpected error: %v", err)
+		}
+	}
 }
@@ -0,0 +1,24 @@
+package dnsresolvr
+
+// KeyTag computes the key tag of a DNSKEY record from its record data as described in
+// RFC 4034 Appendix B. RRSIG and DS records refer to the key by this tag.
+func KeyTag(dnskeyRdata []byte) uint16 {
+	// Keys of the obsolete RSA/MD5 algorithm use the most significant 16 of the least
+	// significant 24 bits of the modulus instead.
+	if len(dnskeyRdata) > 3 && dnskeyRdata[3] == 1 {
+		if len(dnskeyRdata) < 7 {
+			return 0
+		}
+		return uint16(dnskeyRdata[len(dnskeyRdata)-3])<<8 | uint16(dnskeyRdata[len(dnskeyRdata)-2])
+	}
+	var accumulator uint32
+	for i, b := range dnskeyRdata {
+		if i&1 == 1 {
+			accumulator += uint32(b)
+		} else {
+			accumulator += uint32(b) << 8
+		}
+	}
+	accumulator += accumulator >> 16 & 65535
+	return uint16(accumulator & 65535)
+}
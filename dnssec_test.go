@@ -0,0 +1,25 @@
+package dnsresolvr
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestKeyTag(t *testing.T) {
+	// The root zone key signing key introduced in 2017.
+	publicKey, err := base64.StdEncoding.DecodeString("AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=")
+	if err != nil {
+		t.Fatalf("Invalid test key: %v", err)
+	}
+	rdata := append([]byte{0x01, 0x01, 3, 8}, publicKey...)
+	if got := KeyTag(rdata); got != 20326 {
+		t.Fatalf("Got: %d, Want: 20326", got)
+	}
+}
+
+func TestKeyTagOfRsaMd5Key(t *testing.T) {
+	rdata := []byte{0x01, 0x00, 3, 1, 0x01, 0x03, 0xaa, 0xbb, 0x12, 0x34, 0x56}
+	if got := KeyTag(rdata); got != 0x1234 {
+		t.Fatalf("Got: %#x, Want: 0x1234", got)
+	}
+}
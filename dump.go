@@ -0,0 +1,43 @@
+package dnsresolvr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dumpTimeLayout orders dump files of the same name and type by the time they were captured.
+const dumpTimeLayout = "20060102T150405.000000000"
+
+// dumpExchange writes the query and the raw response to DumpDir, in files named after the
+// queried name and type and the time of the exchange, like
+// www.example.com_A_20240102T150405.000000000.response.bin.
+func (r *Resolver) dumpExchange(query *DnsQuery, responseBytes []byte) error {
+	name := "root"
+	qtype := "NONE"
+	if len(query.Questions) > 0 {
+		question := query.Questions[0]
+		if domainName := question.GetDomainName(); domainName != "" {
+			name = domainName
+		}
+		qtype = question.Qtype.String()
+	}
+	// Names may hold any byte, keep whatever would make an unusable file name out of it.
+	name = strings.Map(func(c rune) rune {
+		if c == '/' || c == '\\' || c < ' ' {
+			return '_'
+		}
+		return c
+	}, name)
+	prefix := filepath.Join(r.DumpDir, fmt.Sprintf("%s_%s_%s", name, qtype, r.clock().Now().UTC().Format(dumpTimeLayout)))
+	err := os.WriteFile(prefix+".query.bin", query.GetBytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("could not dump query: %w", err)
+	}
+	err = os.WriteFile(prefix+".response.bin", responseBytes, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not dump response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package dnsresolvr
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpDirCapturesExchange(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerExample)
+	dir := t.TempDir()
+	resolver := &Resolver{
+		Servers: []string{server.address()},
+		Clock:   &fakeClock{now: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		DumpDir: dir,
+	}
+	_, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	prefix := filepath.Join(dir, "www.example.com_A_20240102T150405.000000000")
+	response, err := os.ReadFile(prefix + ".response.bin")
+	if err != nil {
+		t.Fatalf("Response was not dumped: %v", err)
+	}
+	queries := server.receivedQueries()
+	if len(queries) != 1 {
+		t.Fatalf("Got %d queries, Want: 1", len(queries))
+	}
+	if want := answerExample(queries[0]); !bytes.Equal(response, want) {
+		t.Fatalf("Got dumped response: %x, Want: %x", response, want)
+	}
+	query, err := os.ReadFile(prefix + ".query.bin")
+	if err != nil {
+		t.Fatalf("Query was not dumped: %v", err)
+	}
+	parsed, err := ParseResponse(query)
+	if err != nil {
+		t.Fatalf("Dumped query does not parse: %v", err)
+	}
+	if parsed.Question.GetDomainName() != "www.example.com" || parsed.Question.Qtype != A {
+		t.Fatalf("Got dumped question: %v, Want: www.example.com A", parsed.Question)
+	}
+}
+
+func TestDumpDirErrorDoesNotFailQuery(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{
+		Servers: []string{server.address()},
+		DumpDir: filepath.Join(t.TempDir(), "missing"),
+	}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Got error: %v, Want: query answered despite the missing dump directory", err)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("Got %d answers, Want: 1", len(response.Answers))
+	}
+}
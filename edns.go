@@ -0,0 +1,200 @@
+package dnsresolvr
+
+import (
+	"bytes"
+	"dnsresolvr/internal/pkg/utils"
+	"errors"
+	"fmt"
+)
+
+const (
+	// defaultUdpPayloadSize is the UDP payload size advertised in the OPT record unless
+	// configured otherwise. It avoids IP fragmentation on common links.
+	defaultUdpPayloadSize = 1232
+	// minUdpMessageSize is the size every DNS implementation must accept over UDP.
+	minUdpMessageSize = 512
+	// ednsCookieOption is the option code of DNS cookies (RFC 7873).
+	ednsCookieOption   = 10
+	clientCookieLength = 8
+	// badCookie is the extended response code of a response to a query whose server cookie
+	// is missing or no longer valid (RFC 7873 section 8).
+	badCookie = 23
+	// ednsPaddingOption is the option code of padding (RFC 7830), which only serves to hide
+	// the size of the message from observers of encrypted transports.
+	ednsPaddingOption = 12
+)
+
+// EdnsOption is a single option carried in the data of an OPT record.
+type EdnsOption struct {
+	Code uint16
+	Data []byte
+}
+
+// Edns describes the OPT pseudo-record (RFC 6891) carried in the additional section of a
+// message to extend the header.
+type Edns struct {
+	UdpPayloadSize uint16
+	ExtendedRcode  uint8
+	Version        uint8
+	DnssecOK       bool
+	Options        []EdnsOption
+}
+
+// GetBytes serializes the OPT record. Its class holds the UDP payload size and its TTL the
+// extended response code, the version and the flags, of which only DO is defined.
+func (e Edns) GetBytes() []byte {
+	var optBytes []byte
+	optBytes = append(optBytes, 0)
+	optBytes = append(optBytes, utils.ConvertUint16ToBytesArray(uint16(OPT))...)
+	optBytes = append(optBytes, utils.ConvertUint16ToBytesArray(e.UdpPayloadSize)...)
+	ttl := uint32(e.ExtendedRcode)<<24 | uint32(e.Version)<<16
+	if e.DnssecOK {
+		ttl |= 32768
+	}
+	optBytes = append(optBytes, utils.ConvertUint32ToBytesArray(ttl)...)
+	var rdata []byte
+	for _, option := range e.Options {
+		rdata = append(rdata, option.getBytes()...)
+	}
+	optBytes = append(optBytes, utils.ConvertUint16ToBytesArray(uint16(len(rdata)))...)
+	return append(optBytes, rdata...)
+}
+
+// Option returns the data of the first option with the given code.
+func (e Edns) Option(code uint16) ([]byte, bool) {
+	for _, option := range e.Options {
+		if option.Code == code {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+// extendedResponseCode combines the response code of the header with the upper eight bits
+// carried by the OPT record, if any (RFC 6891 section 6.1.3).
+func (r DnsResponse) extendedResponseCode() int {
+	responseCode := int(r.Header.ResponseCode)
+	if edns := r.Edns(); edns != nil {
+		responseCode |= int(edns.ExtendedRcode) << 4
+	}
+	return responseCode
+}
+
+func (o EdnsOption) getBytes() []byte {
+	var optionBytes []byte
+	optionBytes = append(optionBytes, utils.ConvertUint16ToBytesArray(o.Code)...)
+	optionBytes = append(optionBytes, utils.ConvertUint16ToBytesArray(uint16(len(o.Data)))...)
+	return append(optionBytes, o.Data...)
+}
+
+// paddingOption returns the padding option that brings a message of messageLength bytes,
+// not counting the option, to a multiple of blockSize (RFC 8467 section 4.1).
+func paddingOption(messageLength int, blockSize int) EdnsOption {
+	paddedLength := messageLength + 4
+	return EdnsOption{Code: ednsPaddingOption, Data: make([]byte, (blockSize-paddedLength%blockSize)%blockSize)}
+}
+
+// Edns returns the EDNS parameters of the OPT record in the additional section, or nil when
+// the response carries none. Padding options carry nothing and are left out.
+func (r DnsResponse) Edns() *Edns {
+	for _, record := range r.AdditionalRecords {
+		if record.RecordType != OPT {
+			continue
+		}
+		var options []EdnsOption
+		for _, option := range record.EdnsOptions {
+			if option.Code != ednsPaddingOption {
+				options = append(options, option)
+			}
+		}
+		return &Edns{
+			UdpPayloadSize: uint16(record.RecordClass),
+			ExtendedRcode:  uint8(record.TTL >> 24),
+			Version:        uint8(record.TTL >> 16),
+			DnssecOK:       record.TTL&32768 == 32768,
+			Options:        options,
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) udpPayloadSize() uint16 {
+	if r.UdpPayloadSize == 0 {
+		return defaultUdpPayloadSize
+	}
+	return r.UdpPayloadSize
+}
+
+// readBufferSize defaults to the advertised payload size so that any response the server
+// may send is read whole.
+func (r *Resolver) readBufferSize() int {
+	if r.ReadBufferSize == 0 {
+		return int(r.udpPayloadSize())
+	}
+	return r.ReadBufferSize
+}
+
+// checkBufferSizes validates UdpPayloadSize and ReadBufferSize.
+func (r *Resolver) checkBufferSizes() error {
+	if r.UdpPayloadSize != 0 && r.UdpPayloadSize < minUdpMessageSize {
+		return fmt.Errorf("UDP payload size %d is below the minimum of %d", r.UdpPayloadSize, minUdpMessageSize)
+	}
+	if r.ReadBufferSize != 0 && (r.ReadBufferSize < minUdpMessageSize || r.ReadBufferSize > 65535) {
+		return fmt.Errorf("read buffer size %d is outside of [%d, 65535]", r.ReadBufferSize, minUdpMessageSize)
+	}
+	return nil
+}
+
+// cookieOption builds the cookie option sent to server: our client cookie followed by the
+// server cookie it handed out last, if any.
+func (r *Resolver) cookieOption(server string) EdnsOption {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clientCookie == nil {
+		r.clientCookie = utils.GetRandomBytes(clientCookieLength)
+	}
+	data := append([]byte(nil), r.clientCookie...)
+	data = append(data, r.serverCookies[server]...)
+	return EdnsOption{Code: ednsCookieOption, Data: data}
+}
+
+// checkServerCookie verifies that the cookie in a response from server echoes our client
+// cookie and remembers the server cookie for the next query. Responses without a cookie are
+// accepted from servers that never sent one, since not every server supports cookies. Once a
+// server has sent a cookie, responses to queries carrying a cookie must carry one too (RFC 7873
+// section 5.3), or anyone guessing the ID could answer in its place.
+func (r *Resolver) checkServerCookie(server string, query *DnsQuery, response *DnsResponse) error {
+	var cookie []byte
+	ok := false
+	if edns := response.Edns(); edns != nil {
+		cookie, ok = edns.Option(ednsCookieOption)
+	}
+	if !ok {
+		if query.Edns == nil {
+			return nil
+		}
+		if _, sent := query.Edns.Option(ednsCookieOption); !sent {
+			return nil
+		}
+		r.mu.Lock()
+		_, known := r.serverCookies[server]
+		r.mu.Unlock()
+		if known {
+			return errors.New("missing cookie in response from server " + server + ", which sent one before")
+		}
+		return nil
+	}
+	if len(cookie) < clientCookieLength+8 || len(cookie) > clientCookieLength+32 {
+		return fmt.Errorf("invalid cookie length %d from server %s", len(cookie), server)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !bytes.Equal(cookie[:clientCookieLength], r.clientCookie) {
+		return errors.New("client cookie mismatch in response from server " + server)
+	}
+	if r.serverCookies == nil {
+		r.serverCookies = make(map[string][]byte)
+	}
+	r.serverCookies[server] = append([]byte(nil), cookie[clientCookieLength:]...)
+	return nil
+}
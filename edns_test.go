@@ -0,0 +1,264 @@
+package dnsresolvr
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDnssecOKSetsDoBit(t *testing.T) {
+	resolver := &Resolver{DnssecOK: true}
+	query, err := ParseResponse(resolver.newQuery("127.0.0.1", "example.com", A).GetBytes())
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	if len(query.AdditionalRecords) != 1 {
+		t.Fatalf("Got %d additional records, Want: 1", len(query.AdditionalRecords))
+	}
+	opt := query.AdditionalRecords[0]
+	if opt.RecordType != OPT || opt.Domain != "" {
+		t.Fatalf("Got record %q of type %d, Want: OPT record for the root", opt.Domain, opt.RecordType)
+	}
+	if opt.TTL&32768 == 0 {
+		t.Fatalf("Got OPT TTL: %#x, Want: DO bit set", opt.TTL)
+	}
+	if opt.RecordClass != defaultUdpPayloadSize {
+		t.Fatalf("Got payload size: %d, Want: %d", opt.RecordClass, defaultUdpPayloadSize)
+	}
+}
+
+func TestQueryWithoutEdnsOptionsHasNoOptRecord(t *testing.T) {
+	resolver := &Resolver{}
+	query, err := ParseResponse(resolver.newQuery("127.0.0.1", "example.com", A).GetBytes())
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	if len(query.AdditionalRecords) != 0 {
+		t.Fatalf("Got %d additional records, Want: 0", len(query.AdditionalRecords))
+	}
+}
+
+// cookieReply answers with the client cookie of the query, or clientCookie when given,
+// followed by a fixed server cookie.
+func cookieReply(query *DnsResponse, clientCookie []byte) []byte {
+	cookie, _ := query.Edns().Option(ednsCookieOption)
+	if clientCookie == nil {
+		clientCookie = cookie[:clientCookieLength]
+	}
+	optData := EdnsOption{Code: ednsCookieOption, Data: append(append([]byte(nil), clientCookie...), "servercookie"...)}.getBytes()
+	return testReply{
+		answers:     []testRecord{aRecord("www.example.com", "93.184.216.34")},
+		additionals: []testRecord{{name: "", rtype: OPT, rdata: optData}},
+	}.bytesFor(query)
+}
+
+func TestCookiesRoundTrip(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return cookieReply(query, nil)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Cookies: true}
+	for i := 0; i < 2; i++ {
+		_, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	queries := server.receivedQueries()
+	first, _ := queries[0].Edns().Option(ednsCookieOption)
+	second, _ := queries[1].Edns().Option(ednsCookieOption)
+	if len(first) != clientCookieLength {
+		t.Fatalf("Got first cookie: %x, Want: %d byte client cookie", first, clientCookieLength)
+	}
+	want := append(append([]byte(nil), first...), "servercookie"...)
+	if !bytes.Equal(second, want) {
+		t.Fatalf("Got second cookie: %x, Want: %x", second, want)
+	}
+}
+
+func TestCookieMismatchIsRejected(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return cookieReply(query, []byte("spoofed!"))
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Cookies: true}
+	_, err := resolver.ResolveType("www.example.com", A)
+	if err == nil || !strings.Contains(err.Error(), "cookie mismatch") {
+		t.Fatalf("Got error: %v, Want: cookie mismatch", err)
+	}
+}
+
+func TestMissingCookieIsRejectedOnceServerSentOne(t *testing.T) {
+	var queries atomic.Int32
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		if queries.Add(1) == 1 {
+			return cookieReply(query, nil)
+		}
+		return answerExample(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Cookies: true}
+	_, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = resolver.ResolveType("www.example.com", A)
+	if err == nil || !strings.Contains(err.Error(), "missing cookie") {
+		t.Fatalf("Got error: %v, Want: missing cookie from a server that sent one before", err)
+	}
+}
+
+func TestBadCookieRetriesWithNewServerCookie(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		cookie, _ := query.Edns().Option(ednsCookieOption)
+		if string(cookie[clientCookieLength:]) == "servercookie" {
+			return cookieReply(query, nil)
+		}
+		optData := EdnsOption{Code: ednsCookieOption, Data: append(cookie[:clientCookieLength:clientCookieLength], "servercookie"...)}.getBytes()
+		// BADCOOKIE is 23: 7 in the header and 1 in the upper bits carried by the OPT TTL.
+		return testReply{
+			rcode:       badCookie & 15,
+			additionals: []testRecord{{name: "", rtype: OPT, ttl: badCookie >> 4 << 24, rdata: optData}},
+		}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Cookies: true}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("Got answers: %v, Want: the A record from the query with the new cookie", response.Answers)
+	}
+	queries := server.receivedQueries()
+	if len(queries) != 2 {
+		t.Fatalf("Got %d queries, Want: the query and its retry with the new cookie", len(queries))
+	}
+	cookie, _ := queries[1].Edns().Option(ednsCookieOption)
+	if string(cookie[clientCookieLength:]) != "servercookie" {
+		t.Fatalf("Got retry cookie: %x, Want: the server cookie from the BADCOOKIE response", cookie)
+	}
+}
+
+func TestQueriesArePadded(t *testing.T) {
+	resolver := &Resolver{PaddingBlockSize: 128, Cookies: true}
+	for _, name := range []string{"example.com", "a-much-longer-name.subdomain.example.com"} {
+		queryBytes := resolver.newQuery("127.0.0.1", name, A).GetBytes()
+		if len(queryBytes)%128 != 0 {
+			t.Fatalf("Got query of %d bytes for %s, Want: a multiple of 128", len(queryBytes), name)
+		}
+		query, err := ParseResponse(queryBytes)
+		if err != nil {
+			t.Fatalf("Error parsing query: %v", err)
+		}
+		padding := query.AdditionalRecords[0].EdnsOptions[1]
+		if padding.Code != ednsPaddingOption || !bytes.Equal(padding.Data, make([]byte, len(padding.Data))) {
+			t.Fatalf("Got option: %+v, Want: zero padding after the cookie", padding)
+		}
+	}
+}
+
+func TestPaddedResponseIsParsed(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		optData := append(EdnsOption{Code: ednsPaddingOption, Data: make([]byte, 40)}.getBytes(),
+			EdnsOption{Code: ednsCookieOption, Data: []byte("0123456789abcdef")}.getBytes()...)
+		return testReply{
+			answers:     []testRecord{aRecord("www.example.com", "93.184.216.34")},
+			additionals: []testRecord{{name: "", rtype: OPT, rdata: optData}},
+		}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: the A record", response.Answers)
+	}
+	edns := response.Edns()
+	if len(edns.Options) != 1 || edns.Options[0].Code != ednsCookieOption {
+		t.Fatalf("Got options: %+v, Want: only the cookie, without the padding", edns.Options)
+	}
+}
+
+func TestFormatErrorRetriesWithoutEdns(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		if query.Edns() != nil {
+			return testReply{rcode: FormatError}.bytesFor(query)
+		}
+		return answerExample(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, DnssecOK: true}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("Got answers: %v, Want: the A record from the plain query", response.Answers)
+	}
+	queries := server.receivedQueries()
+	if len(queries) != 2 || queries[0].Edns() == nil || queries[1].Edns() != nil {
+		t.Fatalf("Got %d queries, Want: one with EDNS then one without", len(queries))
+	}
+}
+
+func TestFormatErrorWithoutEdnsIsNotRetried(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{rcode: FormatError}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil || response.Header.ResponseCode != FormatError {
+		t.Fatalf("Got: %v (%v), Want: the FORMERR response", response, err)
+	}
+	if len(server.receivedQueries()) != 1 {
+		t.Fatalf("Got %d queries, Want: 1", len(server.receivedQueries()))
+	}
+}
+
+func TestOptRecordCarriesConfiguredPayloadSize(t *testing.T) {
+	resolver := &Resolver{UdpPayloadSize: 4096}
+	query, err := ParseResponse(resolver.newQuery("127.0.0.1", "example.com", A).GetBytes())
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	edns := query.Edns()
+	if edns == nil || edns.UdpPayloadSize != 4096 {
+		t.Fatalf("Got EDNS: %+v, Want: payload size 4096", edns)
+	}
+	if resolver.readBufferSize() != 4096 {
+		t.Fatalf("Got read buffer size: %d, Want: 4096", resolver.readBufferSize())
+	}
+}
+
+func TestBufferSizesAreValidated(t *testing.T) {
+	for _, resolver := range []*Resolver{
+		{Servers: []string{"127.0.0.1"}, UdpPayloadSize: 256},
+		{Servers: []string{"127.0.0.1"}, ReadBufferSize: 100},
+		{Servers: []string{"127.0.0.1"}, ReadBufferSize: 70000},
+	} {
+		_, err := resolver.ResolveType("example.com", A)
+		if err == nil || !strings.Contains(err.Error(), "size") {
+			t.Fatalf("Got error: %v, Want: invalid size rejected", err)
+		}
+	}
+}
+
+func TestSmallReadBufferTruncatesLargeResponse(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		var answers []testRecord
+		for i := 0; i < 40; i++ {
+			answers = append(answers, aRecord("www.example.com", "192.0.2."+strconv.Itoa(i)))
+		}
+		return testReply{answers: answers}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, UdpPayloadSize: 4096}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil || len(response.Answers) != 40 {
+		t.Fatalf("Got %v, Want: all 40 answers with a large enough buffer", err)
+	}
+	resolver = &Resolver{Servers: []string{server.address()}, UdpPayloadSize: 4096, ReadBufferSize: 512}
+	_, err = resolver.ResolveType("www.example.com", A)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("Got error: %v, Want: response cut off by the 512 byte buffer", err)
+	}
+}
@@ -0,0 +1,83 @@
+package dnsresolvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Errors returned by the resolver and the parser wrap one of these, so that callers can tell
+// failures apart with errors.Is.
+var (
+	// ErrTimeout is a server that didn't answer in time.
+	ErrTimeout = errors.New("timeout")
+	// ErrNameError is an NXDOMAIN response: the name does not exist.
+	ErrNameError = errors.New("name does not exist")
+	// ErrServerFailure is a SERVFAIL response.
+	ErrServerFailure = errors.New("server failure")
+	// ErrMalformedResponse is a message that could not be parsed.
+	ErrMalformedResponse = errors.New("malformed response")
+	// ErrTruncated is a UDP response cut off by the read buffer.
+	ErrTruncated = errors.New("truncated response")
+)
+
+// isTimeout reports whether err is a network timeout or a context deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return (errors.As(err, &netErr) && netErr.Timeout()) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// DNSError is the error returned when resolving a name fails. It wraps the cause, so
+// errors.Is works with the sentinel errors through it.
+type DNSError struct {
+	// Question is the name that was looked up.
+	Question string
+	Qtype    MessageType
+	// Server is the server that sent the response, empty when no response came back.
+	Server string
+	// Code is the response code, NoError when no response came back.
+	Code ResponseCode
+	Err  error
+}
+
+func (e *DNSError) Error() string {
+	message := fmt.Sprintf("lookup %s %s", e.Question, e.Qtype)
+	if e.Server != "" {
+		message += " on " + e.Server
+	}
+	return message + ": " + e.Err.Error()
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
+
+// responseError returns the error matching the response code of response, or nil for codes
+// that don't need one.
+func responseError(domainName string, qtype MessageType, response *DnsResponse) error {
+	var err error
+	switch response.Header.ResponseCode {
+	case NameError:
+		err = ErrNameError
+	case ServerFailure:
+		err = ErrServerFailure
+	default:
+		return nil
+	}
+	return &DNSError{
+		Question: domainName,
+		Qtype:    qtype,
+		Server:   response.Server,
+		Code:     response.Header.ResponseCode,
+		Err:      err,
+	}
+}
+
+// wrapError wraps err, when it isn't nil, in sentinel.
+func wrapError(sentinel error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
@@ -0,0 +1,98 @@
+package dnsresolvr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNameErrorIsReported(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", zoneHandler(nil))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("missing.example.com", A)
+	if !errors.Is(err, ErrNameError) {
+		t.Fatalf("Got error: %v, Want: ErrNameError", err)
+	}
+	if response == nil || response.Header.ResponseCode != NameError {
+		t.Fatalf("Got response: %v, Want: the NXDOMAIN response along with the error", response)
+	}
+}
+
+func TestServerFailureIsReported(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{rcode: ServerFailure}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	_, err := resolver.ResolveType("www.example.com", A)
+	if !errors.Is(err, ErrServerFailure) {
+		t.Fatalf("Got error: %v, Want: ErrServerFailure", err)
+	}
+}
+
+func TestTimeoutIsReported(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return nil
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Timeout: 50 * time.Millisecond}
+	_, err := resolver.ResolveType("www.example.com", A)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Got error: %v, Want: ErrTimeout", err)
+	}
+	resolver.Timeout = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = resolver.exchange(ctx, generateDnsQuery("www.example.com", A), server.address())
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Got error: %v, Want: ErrTimeout wrapping the context deadline", err)
+	}
+}
+
+// lateContext has a deadline it never reports as done, like a context whose timer has yet to
+// fire when the deadline of a connection set to the same time expires.
+type lateContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c lateContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func TestTimeoutBeforeContextNoticesDeadline(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return nil
+	})
+	ctx := lateContext{Context: context.Background(), deadline: time.Now().Add(50 * time.Millisecond)}
+	_, err := queryDnsWithDialer(ctx, dialUDP, generateDnsQuery("www.example.com", A), server.address(),
+		time.Second, defaultUdpPayloadSize)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Got error: %v, Want: the context deadline", err)
+	}
+}
+
+func TestMalformedResponseIsReported(t *testing.T) {
+	_, err := ParseResponse([]byte{0x12, 0x34, 0x81})
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("Got error: %v, Want: ErrMalformedResponse", err)
+	}
+}
+
+func TestDNSErrorCarriesContext(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{rcode: ServerFailure}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	_, err := resolver.ResolveType("www.example.com", AAAA)
+	var dnsErr *DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("Got error: %v, Want: a DNSError", err)
+	}
+	want := DNSError{Question: "www.example.com", Qtype: AAAA, Server: server.address(), Code: ServerFailure, Err: ErrServerFailure}
+	if *dnsErr != want {
+		t.Fatalf("Got: %+v, Want: %+v", *dnsErr, want)
+	}
+	if got := err.Error(); got != "lookup www.example.com AAAA on "+server.address()+": server failure" {
+		t.Fatalf("Got message: %s", got)
+	}
+}
@@ -0,0 +1,33 @@
+package dnsresolvr
+
+import (
+	"testing"
+)
+
+// FuzzParseResponse checks that malformed responses are reported as errors rather than
+// panics, and that whatever parses can be serialized again and parses back to the same
+// response. The seed corpus in testdata/fuzz holds a few typical responses.
+func FuzzParseResponse(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(make([]byte, 12))
+	f.Fuzz(func(t *testing.T, response []byte) {
+		parsed, err := ParseResponse(response)
+		if err != nil {
+			return
+		}
+		if parsed.Header == nil {
+			t.Fatalf("Got response without header and no error")
+		}
+		serialized, err := parsed.GetBytes()
+		if err != nil {
+			t.Fatalf("Error serializing a response that parsed: %v", err)
+		}
+		reparsed, err := ParseResponse(serialized)
+		if err != nil {
+			t.Fatalf("Error parsing the serialized response %x: %v", serialized, err)
+		}
+		if diff := responseDiff(reparsed, parsed); diff != "" {
+			t.Fatalf("Got differences after serializing the response:\n%s", diff)
+		}
+	})
+}
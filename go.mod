@@ -1,3 +1,5 @@
 module dnsresolvr
 
 go 1.21.5
+
+require golang.org/x/net v0.35.0
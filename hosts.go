@@ -0,0 +1,41 @@
+package dnsresolvr
+
+import (
+	"net"
+	"strings"
+)
+
+// resolveStatic answers from StaticHosts when it has addresses of type qtype for domainName.
+// Like /etc/hosts, only A and AAAA queries are answered and the match ignores case.
+func (r *Resolver) resolveStatic(domainName string, qtype MessageType) (*DnsResponse, bool) {
+	if qtype != A && qtype != AAAA {
+		return nil, false
+	}
+	var answers []DnsAnswer
+	for name, addresses := range r.StaticHosts {
+		if !strings.EqualFold(strings.TrimSuffix(name, "."), domainName) {
+			continue
+		}
+		for _, address := range addresses {
+			ip := net.ParseIP(address)
+			if ip == nil || (ip.To4() != nil) != (qtype == A) {
+				continue
+			}
+			answers = append(answers, DnsAnswer{
+				Domain:      domainName,
+				Address:     ip.String(),
+				RecordType:  qtype,
+				RecordClass: IN,
+				Section:     AnswerSection,
+			})
+		}
+	}
+	if len(answers) == 0 {
+		return nil, false
+	}
+	response := buildErrorResponse(generateDnsQuery(domainName, qtype), NoError)
+	response.Header.IsAuthoritativeAnswer = true
+	response.Answers = answers
+	response.Header.AnswerCount = uint16(len(answers))
+	return response, true
+}
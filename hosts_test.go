@@ -0,0 +1,37 @@
+package dnsresolvr
+
+import (
+	"testing"
+)
+
+func TestResolveFromStaticHosts(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{
+		Servers:     []string{server.address()},
+		StaticHosts: map[string][]string{"Intranet.Example.com.": {"10.0.0.1", "fd00::1", "10.0.0.2"}},
+	}
+	response, err := resolver.ResolveType("intranet.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 2 || response.Answers[0].Address != "10.0.0.1" || response.Answers[1].Address != "10.0.0.2" {
+		t.Fatalf("Got answers: %+v, Want: 10.0.0.1 and 10.0.0.2", response.Answers)
+	}
+	response, err = resolver.ResolveType("intranet.example.com", AAAA)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "fd00::1" {
+		t.Fatalf("Got answers: %+v, Want: fd00::1", response.Answers)
+	}
+	if got := len(server.receivedQueries()); got != 0 {
+		t.Fatalf("Got %d queries to the server, Want: 0", got)
+	}
+	_, err = resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := len(server.receivedQueries()); got != 1 {
+		t.Fatalf("Got %d queries to the server, Want: 1 for a name not in the static map", got)
+	}
+}
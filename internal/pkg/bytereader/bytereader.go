@@ -4,20 +4,61 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 )
 
+// maxPointerJumps bounds the number of compression pointers followed while reading a single
+// name so that pointer loops in malformed messages can't hang the reader.
+const maxPointerJumps = 64
+
+// MaxLabels is the largest number of labels a name can have, since a name is at most 255
+// bytes long in wire format. Names with more labels are rejected.
+const MaxLabels = 127
+
+// MaxNameLength is the largest length of a name in wire format (RFC 1035 section 2.3.4),
+// counting the length bytes of its labels and the terminating zero byte.
+const MaxNameLength = 255
+
+var errNameTooLong = fmt.Errorf("name longer than %d bytes", MaxNameLength)
+
+// errLabelWithDot rejects labels containing a dot, which names written as dotted strings
+// can't represent: "a.b" as a single label would read back as two labels.
+var errLabelWithDot = errors.New("label containing a dot in name")
+
 // ByteReader ... This is wrapper around bytes.Reader so that it returns a slice with number
 // of bytes requested to be read from the underlying slice instead of supplying the slice to
 // read method everytime one wants to read.
 type ByteReader struct {
 	sourceSlice []byte
 	reader      *bytes.Reader
+	// names caches the names found at the targets of compression pointers, shared with the
+	// sub readers, so that the many records pointing to the same owner name in a message
+	// don't decode it again and again.
+	names map[int]cachedName
+}
+
+// cachedName is the name found at an offset of the source.
+type cachedName struct {
+	name string
+	// labels is the number of labels of the name.
+	labels int
+	// length is the length of the labels of the name in wire format, without the terminating
+	// zero byte.
+	length int
+	// jumps is the number of compression pointers followed to read the name.
+	jumps int
+	// end is the position past the last byte read for the name, which must lie within the
+	// source of a reader for it to use the cached name.
+	end int
 }
 
 func NewByteReader(source []byte) *ByteReader {
 	reader := &ByteReader{
 		reader:      bytes.NewReader(source),
 		sourceSlice: source,
+		names:       make(map[int]cachedName),
 	}
 	return reader
 }
@@ -27,6 +68,12 @@ func (b *ByteReader) ReadBytes(numberOfBytesToRead int) ([]byte, error) {
 		return nil, errors.New("reader not initialized")
 	}
 	reader := b.reader
+	if numberOfBytesToRead < 0 {
+		return nil, errors.New("negative number of bytes requested")
+	}
+	if numberOfBytesToRead > 0 && reader.Len() == 0 {
+		return nil, io.EOF
+	}
 	if numberOfBytesToRead > reader.Len() {
 		return nil, errors.New("requested more number of bytes to read than the available bytes")
 	}
@@ -61,8 +108,24 @@ func (b *ByteReader) ReadUint32() (uint32, error) {
 	return result, nil
 }
 
+// SeekPosition moves the reader like io.Seeker does, except that the resulting position must
+// lie within the source. The position is left unchanged otherwise.
 func (b *ByteReader) SeekPosition(offset int, whence int) error {
-	_, err := b.reader.Seek(int64(offset), whence)
+	var position int
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = b.GetCurrentPosition() + offset
+	case io.SeekEnd:
+		position = int(b.reader.Size()) + offset
+	default:
+		return errors.New("invalid whence")
+	}
+	if position < 0 || position > int(b.reader.Size()) {
+		return fmt.Errorf("seek to position %d outside of the source of %d bytes", position, b.reader.Size())
+	}
+	_, err := b.reader.Seek(int64(position), io.SeekStart)
 	if err != nil {
 		return err
 	}
@@ -76,3 +139,196 @@ func (b *ByteReader) GetCurrentPosition() int {
 func (b *ByteReader) GetAvailableBytes() int {
 	return b.reader.Len()
 }
+
+// SubReader returns a reader limited to the next numberOfBytes bytes and advances this reader
+// past them. Positions of the sub reader are still offsets into the whole source, and names
+// read from it can follow compression pointers to anywhere before its end.
+func (b *ByteReader) SubReader(numberOfBytes int) (*ByteReader, error) {
+	if numberOfBytes < 0 || numberOfBytes > b.GetAvailableBytes() {
+		return nil, errors.New("requested more number of bytes to read than the available bytes")
+	}
+	start := b.GetCurrentPosition()
+	end := start + numberOfBytes
+	subReader := NewByteReader(b.sourceSlice[:end])
+	subReader.names = b.names
+	_ = subReader.SeekPosition(start, io.SeekStart)
+	_ = b.SeekPosition(end, io.SeekStart)
+	return subReader, nil
+}
+
+// ReadCharacterString reads a character string: a length byte followed by that many bytes.
+func (b *ByteReader) ReadCharacterString() (string, error) {
+	length, err := b.ReadSingleByte()
+	if err != nil {
+		return "", err
+	}
+	if int(length) > b.GetAvailableBytes() {
+		_ = b.SeekPosition(-1, io.SeekCurrent)
+		return "", errors.New("character string length exceeds the available bytes")
+	}
+	characterString, err := b.ReadBytes(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(characterString), nil
+}
+
+// ReadQname reads a domain name in qname format from the current position, following
+// compression pointers (RFC 1035 section 4.1.4) against the whole source slice. The reader is
+// left right after the name as it appears at the current position.
+func (b *ByteReader) ReadQname() (string, error) {
+	var labels []string
+	// targets are the offsets pointers led to, along with the number of labels, their length
+	// and the jumps read before reaching them, to cache the names found there.
+	type target struct {
+		offset int
+		labels int
+		length int
+		jumps  int
+	}
+	var targets []target
+	// length is the wire length of the labels read so far.
+	length := 0
+	suffix := ""
+	suffixLabels := 0
+	suffixLength := 0
+	end := 0
+	returnPosition := -1
+	jumps := 0
+	for {
+		l, err := b.ReadSingleByte()
+		if err != nil {
+			return "", err
+		}
+		end = max(end, b.GetCurrentPosition())
+		if l == 0 {
+			break
+		}
+		if l&192 == 192 {
+			l2, err := b.ReadSingleByte()
+			if err != nil {
+				return "", err
+			}
+			end = max(end, b.GetCurrentPosition())
+			jumps++
+			if jumps > maxPointerJumps {
+				return "", errors.New("too many compression pointers in name")
+			}
+			if returnPosition < 0 {
+				returnPosition = b.GetCurrentPosition()
+			}
+			offset := int(l&63)<<8 | int(l2)
+			if offset >= len(b.sourceSlice) {
+				return "", errors.New("compression pointer beyond the end of the message")
+			}
+			if pointerPosition := b.GetCurrentPosition() - 2; offset >= pointerPosition {
+				return "", errors.New("compression pointer does not point backwards")
+			}
+			if cached, ok := b.names[offset]; ok && cached.end <= len(b.sourceSlice) {
+				jumps += cached.jumps
+				if jumps > maxPointerJumps {
+					return "", errors.New("too many compression pointers in name")
+				}
+				suffix = cached.name
+				suffixLabels = cached.labels
+				suffixLength = cached.length
+				if len(labels)+suffixLabels > MaxLabels {
+					return "", errors.New("too many labels in name")
+				}
+				if length+suffixLength+1 > MaxNameLength {
+					return "", errNameTooLong
+				}
+				end = max(end, cached.end)
+				break
+			}
+			err = b.SeekPosition(offset, io.SeekStart)
+			if err != nil {
+				return "", err
+			}
+			targets = append(targets, target{offset: offset, labels: len(labels), length: length, jumps: jumps})
+			continue
+		}
+		if l&192 != 0 {
+			return "", errors.New("unsupported label type in name")
+		}
+		label, err := b.ReadBytes(int(l))
+		if err != nil {
+			return "", err
+		}
+		if bytes.IndexByte(label, '.') >= 0 {
+			return "", errLabelWithDot
+		}
+		end = max(end, b.GetCurrentPosition())
+		labels = append(labels, string(label))
+		if len(labels) > MaxLabels {
+			return "", errors.New("too many labels in name")
+		}
+		length += 1 + len(label)
+		if length+1 > MaxNameLength {
+			return "", errNameTooLong
+		}
+	}
+	if returnPosition >= 0 {
+		err := b.SeekPosition(returnPosition, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, t := range targets {
+		b.names[t.offset] = cachedName{
+			name:   joinLabels(labels[t.labels:], suffix),
+			labels: len(labels) - t.labels + suffixLabels,
+			length: length - t.length + suffixLength,
+			jumps:  jumps - t.jumps,
+			end:    end,
+		}
+	}
+	return joinLabels(labels, suffix), nil
+}
+
+// ReadUncompressedQname reads a domain name in qname format like ReadQname but rejects
+// compression pointers, for the names that must not be compressed, such as the signer name of
+// RRSIG records (RFC 4034 section 3.1.7).
+func (b *ByteReader) ReadUncompressedQname() (string, error) {
+	var labels []string
+	length := 0
+	for {
+		l, err := b.ReadSingleByte()
+		if err != nil {
+			return "", err
+		}
+		if l == 0 {
+			break
+		}
+		if l&192 == 192 {
+			return "", errors.New("compression pointer in a name that must not be compressed")
+		}
+		if l&192 != 0 {
+			return "", errors.New("unsupported label type in name")
+		}
+		label, err := b.ReadBytes(int(l))
+		if err != nil {
+			return "", err
+		}
+		if bytes.IndexByte(label, '.') >= 0 {
+			return "", errLabelWithDot
+		}
+		labels = append(labels, string(label))
+		if len(labels) > MaxLabels {
+			return "", errors.New("too many labels in name")
+		}
+		length += 1 + len(label)
+		if length+1 > MaxNameLength {
+			return "", errNameTooLong
+		}
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// joinLabels joins labels into a name ending with suffix.
+func joinLabels(labels []string, suffix string) string {
+	if suffix != "" {
+		labels = append(labels[:len(labels):len(labels)], suffix)
+	}
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,289 @@
+package bytereader
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestReadQnameFollowsPointers(t *testing.T) {
+	message := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'w', 'w', 'w', 0xc0, 0x00, 0xff}
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(13, io.SeekStart)
+	got, err := reader.ReadQname()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != "www.example.com" {
+		t.Fatalf("Got: %s, Want: www.example.com", got)
+	}
+	if reader.GetCurrentPosition() != 19 {
+		t.Fatalf("Got position: %d, Want: 19, right after the pointer", reader.GetCurrentPosition())
+	}
+}
+
+func TestReadQnameRejectsForwardPointer(t *testing.T) {
+	message := []byte{3, 'w', 'w', 'w', 0xc0, 0x06, 3, 'c', 'o', 'm', 0}
+	_, err := NewByteReader(message).ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: forward pointer rejected")
+	}
+}
+
+func TestReadQnameRejectsOutOfRangePointer(t *testing.T) {
+	message := []byte{3, 'w', 'w', 'w', 0xc0, 0xff}
+	_, err := NewByteReader(message).ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: out of range pointer rejected")
+	}
+}
+
+func TestReadQnameRejectsPointerToItself(t *testing.T) {
+	message := []byte{0xc0, 0x00}
+	_, err := NewByteReader(message).ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: pointer loop rejected")
+	}
+}
+
+func TestReadUncompressedQnameRejectsPointers(t *testing.T) {
+	message := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'w', 'w', 'w', 0xc0, 0x00}
+	reader := NewByteReader(message)
+	got, err := reader.ReadUncompressedQname()
+	if err != nil || got != "example.com" {
+		t.Fatalf("Got: %s (%v), Want: example.com", got, err)
+	}
+	_, err = reader.ReadUncompressedQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: compression pointer rejected")
+	}
+}
+
+func TestReadQnameRejectsLabelWithDot(t *testing.T) {
+	message := []byte{3, 'a', '.', 'b', 3, 'c', 'o', 'm', 0}
+	if _, err := NewByteReader(message).ReadQname(); err == nil {
+		t.Fatalf("Got no error, Want: label a.b rejected")
+	}
+	if _, err := NewByteReader(message).ReadUncompressedQname(); err == nil {
+		t.Fatalf("Got no error, Want: label a.b rejected without compression too")
+	}
+}
+
+func TestReadCharacterString(t *testing.T) {
+	reader := NewByteReader([]byte{5, 'h', 'e', 'l', 'l', 'o', 0, 2, 'o', 'k'})
+	for _, want := range []string{"hello", "", "ok"} {
+		got, err := reader.ReadCharacterString()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("Got: %q, Want: %q", got, want)
+		}
+	}
+	if reader.GetAvailableBytes() != 0 {
+		t.Fatalf("Got %d available bytes, Want: 0", reader.GetAvailableBytes())
+	}
+}
+
+func TestReadCharacterStringRejectsExcessiveLength(t *testing.T) {
+	reader := NewByteReader([]byte{10, 's', 'h', 'o', 'r', 't'})
+	_, err := reader.ReadCharacterString()
+	if err == nil {
+		t.Fatalf("Got no error, Want: length beyond the available bytes rejected")
+	}
+	if reader.GetCurrentPosition() != 0 {
+		t.Fatalf("Got position: %d, Want: 0, nothing consumed", reader.GetCurrentPosition())
+	}
+}
+
+func TestSubReaderStopsAtBoundary(t *testing.T) {
+	reader := NewByteReader([]byte{1, 2, 3, 4, 5, 6})
+	_, _ = reader.ReadSingleByte()
+	subReader, err := reader.SubReader(3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err := subReader.ReadBytes(3)
+	if err != nil || !slices.Equal(got, []byte{2, 3, 4}) {
+		t.Fatalf("Got: %v (%v), Want: [2 3 4]", got, err)
+	}
+	_, err = subReader.ReadSingleByte()
+	if err != io.EOF {
+		t.Fatalf("Got error: %v, Want: EOF at the sub reader boundary", err)
+	}
+	next, err := reader.ReadSingleByte()
+	if err != nil || next != 5 {
+		t.Fatalf("Got: %d (%v), Want: 5, the parent reader continues after the sub region", next, err)
+	}
+}
+
+func TestSubReaderFollowsPointersBeforeItsStart(t *testing.T) {
+	message := []byte{3, 'c', 'o', 'm', 0, 0, 5, 2, 'n', 's', 0xc0, 0x00, 0xff}
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(7, io.SeekStart)
+	subReader, err := reader.SubReader(5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err := subReader.ReadQname()
+	if err != nil || got != "ns.com" {
+		t.Fatalf("Got: %s (%v), Want: ns.com", got, err)
+	}
+}
+
+func TestReadQnameRepeatedPointers(t *testing.T) {
+	message := []byte{3, 'w', 'w', 'w', 3, 'c', 'o', 'm', 0, 2, 'n', 's', 0xc0, 0x04, 0xc0, 0x00, 0xc0, 0x09, 0xc0, 0x00, 0xc0, 0x09}
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(14, io.SeekStart)
+	for _, want := range []string{"www.com", "ns.com", "www.com", "ns.com"} {
+		got, err := reader.ReadQname()
+		if err != nil || got != want {
+			t.Fatalf("Got: %s (%v), Want: %s", got, err, want)
+		}
+	}
+	if reader.GetAvailableBytes() != 0 {
+		t.Fatalf("Got %d bytes left, Want: the reader after the last pointer", reader.GetAvailableBytes())
+	}
+}
+
+func TestSubReaderIgnoresCachedNameBeyondItsEnd(t *testing.T) {
+	// The label at offset 1 spans the pointer at offset 3, which is only complete in the
+	// whole message.
+	message := []byte{0, 3, 0xc0, 0x01, 'x', 0, 0xc0, 0x01}
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(6, io.SeekStart)
+	got, err := reader.ReadQname()
+	if want := "\xc0\x01x"; err != nil || got != want {
+		t.Fatalf("Got: %q (%v), Want: %q", got, err, want)
+	}
+	_ = reader.SeekPosition(2, io.SeekStart)
+	subReader, err := reader.SubReader(2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = subReader.ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: name running past the end of the sub reader rejected")
+	}
+}
+
+func TestSubReaderRejectsLengthBeyondAvailableBytes(t *testing.T) {
+	_, err := NewByteReader([]byte{1, 2}).SubReader(3)
+	if err == nil {
+		t.Fatalf("Got no error, Want: sub reader longer than the source rejected")
+	}
+}
+
+func TestSeekPositionRejectsNegativePosition(t *testing.T) {
+	reader := NewByteReader([]byte{1, 2, 3, 4})
+	_ = reader.SeekPosition(2, io.SeekStart)
+	for _, whence := range []int{io.SeekStart, io.SeekCurrent, io.SeekEnd} {
+		err := reader.SeekPosition(-5, whence)
+		if err == nil {
+			t.Fatalf("Got no error for whence %d, Want: negative position rejected", whence)
+		}
+		if reader.GetCurrentPosition() != 2 {
+			t.Fatalf("Got position: %d, Want: 2, unchanged", reader.GetCurrentPosition())
+		}
+	}
+}
+
+func TestSeekPositionRejectsPositionPastEnd(t *testing.T) {
+	reader := NewByteReader([]byte{1, 2, 3, 4})
+	for _, whence := range []int{io.SeekStart, io.SeekCurrent, io.SeekEnd} {
+		err := reader.SeekPosition(5, whence)
+		if err == nil {
+			t.Fatalf("Got no error for whence %d, Want: position past the end rejected", whence)
+		}
+	}
+	// A seek from past the end back into the source would otherwise land after the end.
+	_ = reader.SeekPosition(-1, io.SeekCurrent)
+	if reader.GetCurrentPosition() != 0 {
+		t.Fatalf("Got position: %d, Want: 0", reader.GetCurrentPosition())
+	}
+	err := reader.SeekPosition(4, io.SeekStart)
+	if err != nil {
+		t.Fatalf("Got error: %v, Want: seeking to the very end allowed", err)
+	}
+	if reader.GetAvailableBytes() != 0 {
+		t.Fatalf("Got %d available bytes, Want: 0", reader.GetAvailableBytes())
+	}
+}
+
+func TestReadQnameRejectsTooManyLabels(t *testing.T) {
+	var message []byte
+	for i := 0; i < 200; i++ {
+		message = append(message, 1, 'a')
+	}
+	message = append(message, 0)
+	_, err := NewByteReader(message).ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: 200 label name rejected")
+	}
+	// A name of 100 labels, a pointer to it and then 100 more labels followed by the same
+	// pointer, which is resolved from the names cached by the first pointer.
+	message = nil
+	for i := 0; i < 100; i++ {
+		message = append(message, 1, 'a')
+	}
+	message = append(message, 0, 0xc0, 0x00)
+	secondName := len(message)
+	message = append(message, message[:200]...)
+	message = append(message, 0xc0, 0x00)
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(201, io.SeekStart)
+	_, err = reader.ReadQname()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_ = reader.SeekPosition(secondName, io.SeekStart)
+	_, err = reader.ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: name of 200 labels through a cached pointer target rejected")
+	}
+}
+
+func TestReadQnameRejectsTooLongName(t *testing.T) {
+	// Four labels of 63 bytes make a name of 257 bytes, over the limit of 255 bytes, with far
+	// fewer than 127 labels. Three such labels followed by one of 61 bytes make 255 bytes.
+	longName := func(lastLabel int) []byte {
+		var name []byte
+		for i, length := range []int{63, 63, 63, lastLabel} {
+			name = append(name, byte(length))
+			name = append(name, bytes.Repeat([]byte{'a' + byte(i)}, length)...)
+		}
+		return append(name, 0)
+	}
+	_, err := NewByteReader(longName(61)).ReadQname()
+	if err != nil {
+		t.Fatalf("Unexpected error for a name of 255 bytes: %v", err)
+	}
+	_, err = NewByteReader(longName(63)).ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: name of 257 bytes rejected")
+	}
+	_, err = NewByteReader(longName(63)).ReadUncompressedQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: uncompressed name of 257 bytes rejected")
+	}
+	// The 192 bytes of the last three labels of the name at offset 0 are cached by the first
+	// read through a pointer to them; a second name of two 63 byte labels followed by a
+	// pointer to them is 321 bytes long.
+	message := longName(63)
+	message = append(message, 0xc0, 64)
+	secondName := len(message)
+	message = append(message, longName(63)[:128]...)
+	message = append(message, 0xc0, 64)
+	reader := NewByteReader(message)
+	_ = reader.SeekPosition(secondName-2, io.SeekStart)
+	_, err = reader.ReadQname()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_ = reader.SeekPosition(secondName, io.SeekStart)
+	_, err = reader.ReadQname()
+	if err == nil {
+		t.Fatalf("Got no error, Want: name of 321 bytes through a cached pointer target rejected")
+	}
+}
@@ -0,0 +1,55 @@
+package bytereader
+
+import (
+	"io"
+	"testing"
+)
+
+// FuzzByteReader runs the sequence of reads and seeks encoded in ops against source. Each
+// operation is an opcode byte followed by an argument byte. Seeks must land where they were
+// asked to or fail, and after every operation the position and the available bytes must
+// still add up to the length of the source.
+func FuzzByteReader(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7}, []byte{0, 3, 1, 0, 2, 0, 3, 0})
+	f.Add([]byte{0xde, 0xad, 0xbe, 0xef}, []byte{3, 0xff, 4, 2, 0, 0xfe})
+	f.Add([]byte{}, []byte{1, 0, 3, 5, 0, 0})
+	f.Fuzz(func(t *testing.T, source []byte, ops []byte) {
+		reader := NewByteReader(source)
+		for i := 0; i+1 < len(ops); i += 2 {
+			arg := int(int8(ops[i+1]))
+			before := reader.GetCurrentPosition()
+			switch ops[i] % 6 {
+			case 0:
+				_, _ = reader.ReadBytes(arg)
+			case 1:
+				_, _ = reader.ReadUint16()
+			case 2:
+				_, _ = reader.ReadUint32()
+			case 3:
+				checkSeek(t, reader, arg, io.SeekStart, arg)
+			case 4:
+				checkSeek(t, reader, arg, io.SeekCurrent, before+arg)
+			case 5:
+				checkSeek(t, reader, arg, io.SeekEnd, len(source)+arg)
+			}
+			position := reader.GetCurrentPosition()
+			available := reader.GetAvailableBytes()
+			if position+available != len(source) {
+				t.Fatalf("Got position %d and %d available bytes after op %d, Want: %d in total", position, available, i/2, len(source))
+			}
+		}
+	})
+}
+
+// checkSeek seeks and verifies that the reader ends up at want when that lies within the
+// source, and that the seek fails otherwise.
+func checkSeek(t *testing.T, reader *ByteReader, offset int, whence int, want int) {
+	err := reader.SeekPosition(offset, whence)
+	inRange := want >= 0 && want <= int(reader.reader.Size())
+	if inRange && (err != nil || reader.GetCurrentPosition() != want) {
+		t.Fatalf("Got position %d and error %v, Want: position %d", reader.GetCurrentPosition(), err, want)
+	}
+	if !inRange && err == nil {
+		t.Fatalf("Got no error seeking to %d, Want: out of range seek rejected", want)
+	}
+}
@@ -15,12 +15,37 @@ func GetRandomUint16() uint16 {
 	return uint16(randInt.Uint64())
 }
 
+// GetRandomFloat64 returns a random number in [0, 1).
+func GetRandomFloat64() float64 {
+	randInt, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+	if err != nil {
+		os.Exit(2)
+	}
+	return float64(randInt.Uint64()) / (1 << 53)
+}
+
+// GetRandomBytes returns n cryptographically random bytes.
+func GetRandomBytes(n int) []byte {
+	randBytes := make([]byte, n)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		os.Exit(2)
+	}
+	return randBytes
+}
+
 func ConvertUint16ToBytesArray(number uint16) []byte {
 	numBytes := make([]byte, 2)
 	binary.BigEndian.PutUint16(numBytes, number)
 	return numBytes
 }
 
+func ConvertUint32ToBytesArray(number uint32) []byte {
+	numBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(numBytes, number)
+	return numBytes
+}
+
 func GetUint16FromBytes(bytesToConvert []byte) uint16 {
 	return binary.BigEndian.Uint16(bytesToConvert)
 }
@@ -0,0 +1,155 @@
+package dnsresolvr
+
+import (
+	"cmp"
+	"dnsresolvr/internal/pkg/utils"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxCnameChainLength bounds the number of CNAME records followed from a name so that alias
+// loops terminate.
+const maxCnameChainLength = 16
+
+// LookupCNAME returns the canonical name of host, following its chain of CNAME records to
+// the end, or host itself when it isn't an alias. Like every name in this package it is
+// returned without the trailing dot.
+func (r *Resolver) LookupCNAME(host string) (string, error) {
+	name := strings.TrimSuffix(host, ".")
+	hops := 0
+	for {
+		response, err := r.ResolveType(name, A)
+		if err != nil {
+			return "", err
+		}
+		target, followed := followCnameChain(name, response.Answers)
+		hops += followed
+		if hops > maxCnameChainLength {
+			return "", fmt.Errorf("CNAME chain of %s longer than %d", host, maxCnameChainLength)
+		}
+		// Servers normally answer with the whole chain, but one that only gave out the first
+		// aliases leaves the rest to be asked for.
+		if followed == 0 || hasRecordsFor(target, response.Answers) {
+			return target, nil
+		}
+		name = target
+	}
+}
+
+// LookupSRV looks up the SRV records of _service._proto.name, or of name alone when service
+// and proto are both empty. Like net.Resolver.LookupSRV it returns the canonical name the
+// records belong to along with the records, sorted by priority and then with the heaviest
+// weight first.
+func (r *Resolver) LookupSRV(service, proto, name string) (string, []SrvData, error) {
+	name = strings.TrimSuffix(name, ".")
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	response, err := r.ResolveType(name, SRV)
+	if err != nil {
+		return "", nil, err
+	}
+	cname, _ := followCnameChain(name, response.Answers)
+	var records []SrvData
+	for _, answer := range response.Answers {
+		if answer.RecordType == SRV && answer.Srv != nil && strings.EqualFold(answer.Domain, cname) {
+			records = append(records, *answer.Srv)
+		}
+	}
+	slices.SortStableFunc(records, func(a, b SrvData) int {
+		if a.Priority != b.Priority {
+			return cmp.Compare(a.Priority, b.Priority)
+		}
+		return cmp.Compare(b.Weight, a.Weight)
+	})
+	return cname, records, nil
+}
+
+// LookupTXT looks up the TXT records of name. Like net.Resolver.LookupTXT it returns one
+// string per record, the character strings of a record joined together.
+func (r *Resolver) LookupTXT(name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".")
+	response, err := r.ResolveType(name, TXT)
+	if err != nil {
+		return nil, err
+	}
+	owner, _ := followCnameChain(name, response.Answers)
+	var txts []string
+	for _, answer := range response.Answers {
+		if answer.RecordType == TXT && strings.EqualFold(answer.Domain, owner) {
+			txts = append(txts, strings.Join(answer.Txt, ""))
+		}
+	}
+	return txts, nil
+}
+
+// SelectSRV picks the record to contact first among records, as laid out in RFC 2782: one of
+// those with the lowest priority, chosen at random in proportion to their weights. Records of
+// weight zero are only picked once in a while, or evenly when all weights are zero. It returns
+// the zero SrvData when there are no records.
+func SelectSRV(records []SrvData) SrvData {
+	if len(records) == 0 {
+		return SrvData{}
+	}
+	priority := records[0].Priority
+	for _, record := range records[1:] {
+		priority = min(priority, record.Priority)
+	}
+	var candidates []SrvData
+	totalWeight := 0
+	for _, record := range records {
+		if record.Priority == priority {
+			candidates = append(candidates, record)
+			totalWeight += int(record.Weight)
+		}
+	}
+	if totalWeight == 0 {
+		return candidates[int(utils.GetRandomFloat64()*float64(len(candidates)))]
+	}
+	// Records of weight zero go first so that they are picked when the random number is zero.
+	slices.SortStableFunc(candidates, func(a, b SrvData) int {
+		return cmp.Compare(min(a.Weight, 1), min(b.Weight, 1))
+	})
+	pick := int(utils.GetRandomFloat64() * float64(totalWeight+1))
+	runningWeight := 0
+	for _, candidate := range candidates {
+		runningWeight += int(candidate.Weight)
+		if runningWeight >= pick {
+			return candidate
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// followCnameChain follows the CNAME records among answers from name and returns the name
+// the chain ends at along with the number of records followed, at most one more than
+// maxCnameChainLength.
+func followCnameChain(name string, answers []DnsAnswer) (string, int) {
+	hops := 0
+	for hops <= maxCnameChainLength {
+		next := ""
+		for _, answer := range answers {
+			if answer.RecordType == CNAME && strings.EqualFold(answer.Domain, name) {
+				next = answer.Target
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+		hops++
+	}
+	return name, hops
+}
+
+// hasRecordsFor reports whether answers hold a record other than a CNAME owned by name.
+func hasRecordsFor(name string, answers []DnsAnswer) bool {
+	for _, answer := range answers {
+		if answer.RecordType != CNAME && strings.EqualFold(answer.Domain, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package dnsresolvr
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func cnameRecord(name string, target string) testRecord {
+	return testRecord{name: name, rtype: CNAME, ttl: 300, rdata: getDomainNameInQnameFormat(target)}
+}
+
+// answerByName answers each query with the records listed for its name, or with NXDOMAIN.
+func answerByName(records map[string][]testRecord) mockHandler {
+	return func(query *DnsResponse) []byte {
+		answers, ok := records[strings.ToLower(query.Question.GetDomainName())]
+		if !ok {
+			return testReply{rcode: NameError}.bytesFor(query)
+		}
+		return testReply{answers: answers}.bytesFor(query)
+	}
+}
+
+func TestLookupCNAMEFollowsChain(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerByName(map[string][]testRecord{
+		"www.example.com": {
+			cnameRecord("www.example.com", "web.example.com"),
+			cnameRecord("web.example.com", "cdn.example.net"),
+			aRecord("cdn.example.net", "192.0.2.80"),
+		},
+		"example.com": {aRecord("example.com", "192.0.2.1")},
+	}))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	canonical, err := resolver.LookupCNAME("WWW.example.com.")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if canonical != "cdn.example.net" {
+		t.Fatalf("Got: %s, Want: cdn.example.net", canonical)
+	}
+	canonical, err = resolver.LookupCNAME("example.com")
+	if err != nil || canonical != "example.com" {
+		t.Fatalf("Got: %s (%v), Want: example.com for a name without CNAME", canonical, err)
+	}
+}
+
+func TestLookupCNAMEAsksForRestOfChain(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerByName(map[string][]testRecord{
+		"www.example.com": {cnameRecord("www.example.com", "web.example.com")},
+		"web.example.com": {cnameRecord("web.example.com", "cdn.example.net")},
+		"cdn.example.net": {aRecord("cdn.example.net", "192.0.2.80")},
+		"loop.example.com": {
+			cnameRecord("loop.example.com", "pool.example.com"),
+			cnameRecord("pool.example.com", "loop.example.com"),
+		},
+	}))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	canonical, err := resolver.LookupCNAME("www.example.com")
+	if err != nil || canonical != "cdn.example.net" {
+		t.Fatalf("Got: %s (%v), Want: cdn.example.net", canonical, err)
+	}
+	_, err = resolver.LookupCNAME("loop.example.com")
+	if err == nil || !strings.Contains(err.Error(), "CNAME chain") {
+		t.Fatalf("Got error: %v, Want: CNAME loop reported", err)
+	}
+}
+
+func srvRecord(name string, priority, weight, port uint16, target string) testRecord {
+	rdata := []byte{byte(priority >> 8), byte(priority), byte(weight >> 8), byte(weight), byte(port >> 8), byte(port)}
+	return testRecord{name: name, rtype: SRV, ttl: 300, rdata: append(rdata, getDomainNameInQnameFormat(target)...)}
+}
+
+func TestLookupSRVSortsRecords(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerByName(map[string][]testRecord{
+		"_sip._tcp.example.com": {
+			srvRecord("_sip._tcp.example.com", 20, 0, 5060, "backup.example.com"),
+			srvRecord("_sip._tcp.example.com", 10, 10, 5060, "small.example.com"),
+			srvRecord("_sip._tcp.example.com", 10, 60, 5061, "big.example.com"),
+		},
+	}))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	cname, records, err := resolver.LookupSRV("sip", "tcp", "example.com.")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cname != "_sip._tcp.example.com" {
+		t.Fatalf("Got name: %s, Want: _sip._tcp.example.com", cname)
+	}
+	want := []SrvData{
+		{Priority: 10, Weight: 60, Port: 5061, Target: "big.example.com"},
+		{Priority: 10, Weight: 10, Port: 5060, Target: "small.example.com"},
+		{Priority: 20, Weight: 0, Port: 5060, Target: "backup.example.com"},
+	}
+	if !slices.Equal(records, want) {
+		t.Fatalf("Got: %+v, Want: %+v", records, want)
+	}
+	query := server.receivedQueries()[0].Question
+	if query.GetDomainName() != "_sip._tcp.example.com" || query.Qtype != SRV {
+		t.Fatalf("Got query: %s %s, Want: _sip._tcp.example.com SRV", query.GetDomainName(), query.Qtype)
+	}
+}
+
+func TestSelectSRVFollowsWeights(t *testing.T) {
+	records := []SrvData{
+		{Priority: 1, Weight: 10, Target: "a.example.com"},
+		{Priority: 1, Weight: 30, Target: "b.example.com"},
+		{Priority: 2, Weight: 100, Target: "backup.example.com"},
+		{Priority: 1, Weight: 60, Target: "c.example.com"},
+	}
+	const rounds = 20000
+	picks := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		picks[SelectSRV(records).Target]++
+	}
+	if picks["backup.example.com"] != 0 {
+		t.Fatalf("Got the priority 2 record %d times, Want: never", picks["backup.example.com"])
+	}
+	for _, record := range records {
+		if record.Priority != 1 {
+			continue
+		}
+		want := float64(record.Weight) / 100
+		got := float64(picks[record.Target]) / rounds
+		if math.Abs(got-want) > 0.02 {
+			t.Fatalf("Got %s picked %.3f of the time, Want: about %.2f", record.Target, got, want)
+		}
+	}
+	if got := SelectSRV(nil); got != (SrvData{}) {
+		t.Fatalf("Got: %+v, Want: the zero SrvData without records", got)
+	}
+}
+
+func TestLookupTXTJoinsCharacterStrings(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerByName(map[string][]testRecord{
+		"example.com": {
+			{name: "example.com", rtype: TXT, ttl: 300, rdata: []byte{11, 'v', '=', 's', 'p', 'f', '1', ' ', '-', 'a', 'l', 'l'}},
+			{name: "example.com", rtype: TXT, ttl: 300, rdata: []byte{6, 'h', 'e', 'l', 'l', 'o', ' ', 5, 'w', 'o', 'r', 'l', 'd'}},
+		},
+	}))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	txts, err := resolver.LookupTXT("example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(txts, []string{"v=spf1 -all", "hello world"}) {
+		t.Fatalf("Got: %q, Want: one string per record", txts)
+	}
+}
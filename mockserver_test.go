@@ -0,0 +1,245 @@
+package dnsresolvr
+
+import (
+	"dnsresolvr/internal/pkg/utils"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// mockHandler builds the raw reply for a parsed query. Returning nil drops the query.
+type mockHandler func(query *DnsResponse) []byte
+
+type mockServer struct {
+	conn    *net.UDPConn
+	handler mockHandler
+	mu      sync.Mutex
+	queries []*DnsResponse
+}
+
+// startMockServer serves handler over UDP on address until the test or benchmark finishes.
+func startMockServer(tb testing.TB, address string, handler mockHandler) *mockServer {
+	tb.Helper()
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		tb.Fatalf("Invalid mock server address %s: %v", address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		tb.Fatalf("Could not start mock server on %s: %v", address, err)
+	}
+	server := &mockServer{conn: conn, handler: handler}
+	tb.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go server.serve()
+	return server
+}
+
+func (s *mockServer) serve() {
+	buffer := make([]byte, 65535)
+	for {
+		n, remote, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		query, err := ParseResponse(buffer[:n])
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.queries = append(s.queries, query)
+		s.mu.Unlock()
+		reply := s.handler(query)
+		if reply != nil {
+			_, _ = s.conn.WriteToUDP(reply, remote)
+		}
+	}
+}
+
+// mockTCPServer serves a handler over TCP, answering any number of queries per connection.
+type mockTCPServer struct {
+	listener    net.Listener
+	handler     mockHandler
+	connections atomic.Int32
+}
+
+// startMockTCPServer serves handler over TCP on address until the test or benchmark finishes.
+func startMockTCPServer(tb testing.TB, address string, handler mockHandler) *mockTCPServer {
+	tb.Helper()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		tb.Fatalf("Could not start mock TCP server on %s: %v", address, err)
+	}
+	server := &mockTCPServer{listener: listener, handler: handler}
+	tb.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go server.serve()
+	return server
+}
+
+func (s *mockTCPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.connections.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *mockTCPServer) serveConn(conn net.Conn) {
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	for {
+		message, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		query, err := ParseResponse(message)
+		if err != nil {
+			return
+		}
+		reply := s.handler(query)
+		if reply == nil {
+			continue
+		}
+		_, err = conn.Write(append(utils.ConvertUint16ToBytesArray(uint16(len(reply))), reply...))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *mockTCPServer) address() string {
+	return s.listener.Addr().String()
+}
+
+// receivedQueries returns the queries the server has seen so far.
+func (s *mockServer) receivedQueries() []*DnsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*DnsResponse(nil), s.queries...)
+}
+
+func (s *mockServer) address() string {
+	return s.conn.LocalAddr().String()
+}
+
+// freeMockPort finds a UDP port that is currently free on the loopback interface so that
+// several mock servers on different loopback addresses can share it, just like real name
+// servers all listen on port 53.
+func freeMockPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Could not find a free port: %v", err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	_ = conn.Close()
+	return port
+}
+
+func mockAddress(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+type testRecord struct {
+	name  string
+	rtype MessageType
+	ttl   uint32
+	rdata []byte
+}
+
+func (r testRecord) getBytes() []byte {
+	var recordBytes []byte
+	recordBytes = append(recordBytes, getDomainNameInQnameFormat(r.name)...)
+	recordBytes = append(recordBytes, utils.ConvertUint16ToBytesArray(uint16(r.rtype))...)
+	recordBytes = append(recordBytes, utils.ConvertUint16ToBytesArray(uint16(IN))...)
+	recordBytes = append(recordBytes, byte(r.ttl>>24), byte(r.ttl>>16), byte(r.ttl>>8), byte(r.ttl))
+	recordBytes = append(recordBytes, utils.ConvertUint16ToBytesArray(uint16(len(r.rdata)))...)
+	return append(recordBytes, r.rdata...)
+}
+
+func aRecord(name string, ip string) testRecord {
+	return testRecord{name: name, rtype: A, ttl: 300, rdata: net.ParseIP(ip).To4()}
+}
+
+func nsRecord(name string, host string) testRecord {
+	return testRecord{name: name, rtype: NS, ttl: 300, rdata: getDomainNameInQnameFormat(host)}
+}
+
+// testReply describes a response to be built for a mock server query.
+type testReply struct {
+	rcode         ResponseCode
+	authoritative bool
+	truncated     bool
+	answers       []testRecord
+	authorities   []testRecord
+	additionals   []testRecord
+}
+
+func (reply testReply) bytesFor(query *DnsResponse) []byte {
+	header := DnsHeader{
+		Id:                     query.Header.Id,
+		IsResponse:             true,
+		Opcode:                 query.Header.Opcode,
+		IsAuthoritativeAnswer:  reply.authoritative,
+		IsTruncatedMessage:     reply.truncated,
+		IsRecursionDesired:     query.Header.IsRecursionDesired,
+		ResponseCode:           reply.rcode,
+		QuestionCount:          1,
+		AnswerCount:            uint16(len(reply.answers)),
+		NameServerRecordsCount: uint16(len(reply.authorities)),
+		AdditionalRecordsCount: uint16(len(reply.additionals)),
+	}
+	replyBytes := header.GetBytes()
+	replyBytes = append(replyBytes, query.Question.GetBytes()...)
+	for _, section := range [][]testRecord{reply.answers, reply.authorities, reply.additionals} {
+		for _, record := range section {
+			replyBytes = append(replyBytes, record.getBytes()...)
+		}
+	}
+	return replyBytes
+}
+
+// answerExample answers every query with an A record for www.example.com.
+func answerExample(query *DnsResponse) []byte {
+	return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+}
+
+// zoneHandler answers authoritatively from records, with the records matching the name and
+// type of the question, or with NXDOMAIN when no record has that name.
+func zoneHandler(records []DnsAnswer) mockHandler {
+	return func(query *DnsResponse) []byte {
+		response := &DnsResponse{
+			Header:   &DnsHeader{Id: query.Header.Id, IsResponse: true, IsAuthoritativeAnswer: true, IsRecursionDesired: query.Header.IsRecursionDesired},
+			Question: query.Question,
+		}
+		name := query.Question.GetDomainName()
+		found := false
+		for _, record := range records {
+			if !strings.EqualFold(record.Domain, name) {
+				continue
+			}
+			found = true
+			if query.Question.Qtype.Matches(record.RecordType) {
+				response.Answers = append(response.Answers, record)
+			}
+		}
+		if !found {
+			response.Header.ResponseCode = NameError
+		}
+		responseBytes, err := response.GetBytes()
+		if err != nil {
+			return nil
+		}
+		return responseBytes
+	}
+}
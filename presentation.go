@@ -0,0 +1,298 @@
+package dnsresolvr
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var messageTypeNames = map[MessageType]string{
+	A: "A", NS: "NS", MD: "MD", MF: "MF", CNAME: "CNAME", SOA: "SOA", MB: "MB", MG: "MG",
+	MR: "MR", NULL: "NULL", WKS: "WKS", PTR: "PTR", HINFO: "HINFO", MINFO: "MINFO", MX: "MX",
+	TXT: "TXT", RP: "RP", AFSDB: "AFSDB", X25: "X25", ISDN: "ISDN", RT: "RT", AAAA: "AAAA", LOC: "LOC", SRV: "SRV", NAPTR: "NAPTR", CERT: "CERT", OPT: "OPT", APL: "APL", DS: "DS", SSHFP: "SSHFP", RRSIG: "RRSIG", NSEC: "NSEC",
+	DNSKEY: "DNSKEY", TLSA: "TLSA", SVCB: "SVCB", HTTPS: "HTTPS", SPF: "SPF", EUI48: "EUI48", EUI64: "EUI64", TKEY: "TKEY", TSIG: "TSIG", AXFR: "AXFR", MAILB: "MAILB", MAILA: "MAILA", ANY_TYPE: "ANY", CAA: "CAA",
+}
+
+var messageClassNames = map[MessageClass]string{IN: "IN", CS: "CS", CH: "CH", HS: "HS", ANY: "ANY"}
+
+// String returns the mnemonic of the type, or its generic TYPEnnn form (RFC 3597) when it
+// has none.
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// String returns the mnemonic of the class, or its generic CLASSnnn form (RFC 3597) when it
+// has none.
+func (c MessageClass) String() string {
+	if name, ok := messageClassNames[c]; ok {
+		return name
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+// ParseMessageType returns the type with the given mnemonic, such as "MX", or generic form,
+// such as "TYPE65280". Case is ignored.
+func ParseMessageType(name string) (MessageType, error) {
+	name = strings.ToUpper(name)
+	for t, typeName := range messageTypeNames {
+		if typeName == name {
+			return t, nil
+		}
+	}
+	if strings.HasPrefix(name, "TYPE") {
+		number, err := strconv.ParseUint(name[len("TYPE"):], 10, 16)
+		if err == nil {
+			return MessageType(number), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", name)
+}
+
+// Presentation renders the record in master file format (RFC 1035 section 5.1), for example
+// "example.com. 300 IN MX 10 mail.example.com.". Record data of types without a dedicated
+// format is written in the generic form of RFC 3597.
+func (a DnsAnswer) Presentation() string {
+	return fmt.Sprintf("%s %d %s %s %s", presentationName(a.Domain), a.TTL, a.RecordClass, a.RecordType, a.RdataPresentation())
+}
+
+// RdataPresentation renders only the record data in master file format, for example
+// "10 mail.example.com." for an MX record.
+func (a DnsAnswer) RdataPresentation() string {
+	switch {
+	case a.RecordType == A || a.RecordType == AAAA:
+		return a.Address
+	case a.RecordType == EUI48 || a.RecordType == EUI64:
+		// Identifiers are written with hyphens in master files (RFC 7043 section 3.2).
+		return strings.ReplaceAll(a.Address, ":", "-")
+	case a.RecordType == NS || a.RecordType == MD || a.RecordType == MF || a.RecordType == CNAME ||
+		a.RecordType == MB || a.RecordType == MG || a.RecordType == MR || a.RecordType == PTR:
+		return presentationName(a.Target)
+	case a.RecordType == SOA && a.Soa != nil:
+		soa := a.Soa
+		return fmt.Sprintf("%s %s %d %d %d %d %d", presentationName(soa.Mname), presentationName(soa.Rname),
+			soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)
+	case a.RecordType == MINFO && a.Minfo != nil:
+		return presentationName(a.Minfo.Rmailbx) + " " + presentationName(a.Minfo.Emailbx)
+	case a.RecordType == RP && a.Rp != nil:
+		return presentationName(a.Rp.Mbox) + " " + presentationName(a.Rp.Txt)
+	case a.RecordType == AFSDB && a.Afsdb != nil:
+		return fmt.Sprintf("%d %s", a.Afsdb.Subtype, presentationName(a.Afsdb.Hostname))
+	case a.RecordType == RT && a.Rt != nil:
+		return fmt.Sprintf("%d %s", a.Rt.Preference, presentationName(a.Rt.IntermediateHost))
+	case a.RecordType == MX && a.Mx != nil:
+		return fmt.Sprintf("%d %s", a.Mx.Preference, presentationName(a.Mx.Exchange))
+	case a.RecordType == SRV && a.Srv != nil:
+		return fmt.Sprintf("%d %d %d %s", a.Srv.Priority, a.Srv.Weight, a.Srv.Port, presentationName(a.Srv.Target))
+	case a.RecordType == TXT || a.RecordType == SPF || a.RecordType == X25 || a.RecordType == ISDN:
+		quoted := make([]string, len(a.Txt))
+		for i, txt := range a.Txt {
+			quoted[i] = quoteCharacterString(txt)
+		}
+		return strings.Join(quoted, " ")
+	case a.RecordType == HINFO && a.Hinfo != nil:
+		return quoteCharacterString(a.Hinfo.Cpu) + " " + quoteCharacterString(a.Hinfo.Os)
+	case a.RecordType == CAA && a.Caa != nil:
+		return fmt.Sprintf("%d %s %s", a.Caa.Flags, a.Caa.Tag, quoteCharacterString(a.Caa.Value))
+	case a.RecordType == APL && a.Apl != nil:
+		prefixes := make([]string, len(a.Apl.Prefixes))
+		for i, prefix := range a.Apl.Prefixes {
+			prefixes[i] = prefix.presentation()
+		}
+		return strings.Join(prefixes, " ")
+	case a.RecordType == DS && a.Ds != nil:
+		return fmt.Sprintf("%d %d %d %s", a.Ds.KeyTag, a.Ds.Algorithm, a.Ds.DigestType, strings.ToUpper(hex.EncodeToString(a.Ds.Digest)))
+	case a.RecordType == SSHFP && a.Sshfp != nil:
+		return fmt.Sprintf("%d %d %s", a.Sshfp.Algorithm, a.Sshfp.FingerprintType, a.Sshfp.Fingerprint)
+	case a.RecordType == CERT && a.Cert != nil:
+		return fmt.Sprintf("%d %d %d %s", a.Cert.Type, a.Cert.KeyTag, a.Cert.Algorithm, base64.StdEncoding.EncodeToString(a.Cert.Certificate))
+	case a.RecordType == DNSKEY && a.Dnskey != nil:
+		return fmt.Sprintf("%d %d %d %s", a.Dnskey.Flags, a.Dnskey.Protocol, a.Dnskey.Algorithm, base64.StdEncoding.EncodeToString(a.Dnskey.PublicKey))
+	case a.RecordType == RRSIG && a.Rrsig != nil:
+		rrsig := a.Rrsig
+		return fmt.Sprintf("%s %d %d %d %s %s %d %s %s", rrsig.TypeCovered, rrsig.Algorithm, rrsig.Labels,
+			rrsig.OriginalTTL, presentationTime(rrsig.Expiration), presentationTime(rrsig.Inception), rrsig.KeyTag,
+			presentationName(rrsig.SignerName), base64.StdEncoding.EncodeToString(rrsig.Signature))
+	case a.RecordType == LOC && a.Loc != nil:
+		return a.Loc.presentation()
+	case a.RecordType == NAPTR && a.Naptr != nil:
+		naptr := a.Naptr
+		return fmt.Sprintf("%d %d %s %s %s %s", naptr.Order, naptr.Preference, quoteCharacterString(naptr.Flags),
+			quoteCharacterString(naptr.Service), quoteCharacterString(naptr.Regexp), presentationName(naptr.Replacement))
+	case a.RecordType == TLSA && a.Tlsa != nil:
+		return fmt.Sprintf("%d %d %d %s", a.Tlsa.Usage, a.Tlsa.Selector, a.Tlsa.MatchingType,
+			strings.ToUpper(hex.EncodeToString(a.Tlsa.CertificateAssociationData)))
+	case (a.RecordType == SVCB || a.RecordType == HTTPS) && a.Svcb != nil:
+		fields := []string{strconv.Itoa(int(a.Svcb.Priority)), presentationName(a.Svcb.Target)}
+		for _, param := range a.Svcb.Params {
+			fields = append(fields, param.presentation())
+		}
+		return strings.Join(fields, " ")
+	case a.RecordType == NSEC && a.Nsec != nil:
+		fields := []string{presentationName(a.Nsec.NextDomain)}
+		for _, t := range a.Nsec.Types {
+			fields = append(fields, t.String())
+		}
+		return strings.Join(fields, " ")
+	}
+	if len(a.RawData) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %s`, len(a.RawData), hex.EncodeToString(a.RawData))
+}
+
+func (p AplPrefix) presentation() string {
+	address := make([]byte, 4)
+	if p.Family == 2 {
+		address = make([]byte, 16)
+	}
+	copy(address, p.AfdPart)
+	negation := ""
+	if p.Negation {
+		negation = "!"
+	}
+	return fmt.Sprintf("%s%d:%s/%d", negation, p.Family, net.IP(address), p.PrefixLength)
+}
+
+// presentation writes the location as laid out in RFC 1876 section 3, for example
+// "42 21 54.000 N 71 6 18.000 W -24m 30m 10000m 10m".
+func (l *LocData) presentation() string {
+	return fmt.Sprintf("%s %s %sm %sm %sm %sm", locCoordinate(l.Latitude, "N", "S"), locCoordinate(l.Longitude, "E", "W"),
+		locMeters(l.Altitude), locMeters(l.Size), locMeters(l.HorizontalPrecision), locMeters(l.VerticalPrecision))
+}
+
+// locCoordinate writes a latitude or longitude in degrees as degrees, minutes and seconds
+// followed by the hemisphere.
+func locCoordinate(degrees float64, positive string, negative string) string {
+	hemisphere := positive
+	if degrees < 0 {
+		hemisphere = negative
+	}
+	milliseconds := int64(math.Round(math.Abs(degrees) * 3600000))
+	return fmt.Sprintf("%d %d %d.%03d %s", milliseconds/3600000, milliseconds/60000%60,
+		milliseconds/1000%60, milliseconds%1000, hemisphere)
+}
+
+func locMeters(meters float64) string {
+	return strconv.FormatFloat(math.Round(meters*100)/100, 'f', -1, 64)
+}
+
+// svcParamKeyNames are the mnemonics of the SvcParamKeys defined in RFC 9460 section 14.3.2.
+var svcParamKeyNames = []string{"mandatory", "alpn", "no-default-alpn", "port", "ipv4hint", "ech", "ipv6hint"}
+
+func svcParamKeyName(key uint16) string {
+	if int(key) < len(svcParamKeyNames) {
+		return svcParamKeyNames[key]
+	}
+	return "key" + strconv.Itoa(int(key))
+}
+
+// presentation writes the parameter as key=value (RFC 9460 section 2.1). Values of unknown
+// keys, and values that are malformed for their key, are written as a quoted string.
+func (p SvcParam) presentation() string {
+	name := svcParamKeyName(p.Key)
+	value, ok := p.valuePresentation()
+	if !ok {
+		if len(p.Value) == 0 {
+			return name
+		}
+		return name + "=" + quoteCharacterString(string(p.Value))
+	}
+	if value == "" {
+		return name
+	}
+	return name + "=" + value
+}
+
+func (p SvcParam) valuePresentation() (string, bool) {
+	var values []string
+	switch p.Key {
+	case 0:
+		if len(p.Value) == 0 || len(p.Value)%2 != 0 {
+			return "", false
+		}
+		for i := 0; i < len(p.Value); i += 2 {
+			values = append(values, svcParamKeyName(uint16(p.Value[i])<<8|uint16(p.Value[i+1])))
+		}
+	case 1:
+		for rest := p.Value; len(rest) != 0; {
+			length := int(rest[0])
+			if length == 0 || length >= len(rest) {
+				return "", false
+			}
+			// Commas and backslashes within a protocol ID are escaped before the list is quoted
+			// (RFC 9460 appendix A.1).
+			id := strings.ReplaceAll(string(rest[1:1+length]), `\`, `\\`)
+			values = append(values, strings.ReplaceAll(id, ",", `\,`))
+			rest = rest[1+length:]
+		}
+		if len(values) == 0 {
+			return "", false
+		}
+		return quoteCharacterString(strings.Join(values, ",")), true
+	case 2:
+		return "", len(p.Value) == 0
+	case 3:
+		if len(p.Value) != 2 {
+			return "", false
+		}
+		return strconv.Itoa(int(p.Value[0])<<8 | int(p.Value[1])), true
+	case 4, 6:
+		size := 4
+		if p.Key == 6 {
+			size = 16
+		}
+		if len(p.Value) == 0 || len(p.Value)%size != 0 {
+			return "", false
+		}
+		for i := 0; i < len(p.Value); i += size {
+			values = append(values, net.IP(p.Value[i:i+size]).String())
+		}
+	case 5:
+		if len(p.Value) == 0 {
+			return "", false
+		}
+		return base64.StdEncoding.EncodeToString(p.Value), true
+	default:
+		return "", false
+	}
+	return strings.Join(values, ","), true
+}
+
+// presentationName writes a name fully qualified, with its trailing dot.
+func presentationName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// presentationTime writes a DNSSEC timestamp as YYYYMMDDHHmmSS in UTC (RFC 4034 section 3.2).
+func presentationTime(timestamp uint32) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format("20060102150405")
+}
+
+// quoteCharacterString quotes a character string, escaping quotes and backslashes and
+// writing bytes that aren't printable ASCII as \DDD.
+func quoteCharacterString(s string) string {
+	quoted := strings.Builder{}
+	quoted.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case c < ' ' || c > '~':
+			quoted.WriteString(fmt.Sprintf("\\%03d", c))
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
@@ -0,0 +1,94 @@
+package dnsresolvr
+
+import (
+	"testing"
+)
+
+func TestPresentation(t *testing.T) {
+	tests := []struct {
+		answer DnsAnswer
+		want   string
+	}{
+		{
+			DnsAnswer{Domain: "example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "1.2.3.4"},
+			"example.com. 300 IN A 1.2.3.4",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: MX, RecordClass: IN, TTL: 300, Mx: &MxData{Preference: 10, Exchange: "mail.example.com"}},
+			"example.com. 300 IN MX 10 mail.example.com.",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: TXT, RecordClass: IN, TTL: 60, Txt: []string{"v=spf1 -all", `say "hi"`}},
+			`example.com. 60 IN TXT "v=spf1 -all" "say \"hi\""`,
+		},
+		{
+			DnsAnswer{Domain: "www.example.com", RecordType: CNAME, RecordClass: IN, TTL: 3600, Target: "example.com"},
+			"www.example.com. 3600 IN CNAME example.com.",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: RP, RecordClass: IN, TTL: 300, Rp: &RpData{Mbox: "admin.example.com", Txt: ""}},
+			"example.com. 300 IN RP admin.example.com. .",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: AFSDB, RecordClass: IN, TTL: 300, Afsdb: &AfsdbData{Subtype: 1, Hostname: "afs.example.com"}},
+			"example.com. 300 IN AFSDB 1 afs.example.com.",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: ISDN, RecordClass: IN, TTL: 300, Txt: []string{"150862028003217", "004"}},
+			`example.com. 300 IN ISDN "150862028003217" "004"`,
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: RT, RecordClass: IN, TTL: 300, Rt: &RtData{Preference: 2, IntermediateHost: "relay.example.com"}},
+			"example.com. 300 IN RT 2 relay.example.com.",
+		},
+		{
+			DnsAnswer{Domain: "host.example.com", RecordType: EUI48, RecordClass: IN, TTL: 300, Address: "00:00:5e:00:53:2a"},
+			"host.example.com. 300 IN EUI48 00-00-5e-00-53-2a",
+		},
+		{
+			DnsAnswer{Domain: "_sip._tcp.example.com", RecordType: SRV, RecordClass: IN, TTL: 300, Srv: &SrvData{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com"}},
+			"_sip._tcp.example.com. 300 IN SRV 10 60 5060 sip.example.com.",
+		},
+		{
+			DnsAnswer{Domain: "example.com", RecordType: SVCB, RecordClass: IN, TTL: 300, Svcb: &SvcbData{Priority: 16, Target: "svc.example.com", Params: []SvcParam{
+				{Key: 0, Value: []byte{0, 1}},
+				{Key: 1, Value: []byte{3, 'f', ',', 'g'}},
+				{Key: 2},
+				{Key: 5, Value: []byte{0xfe, 0x0d}},
+				{Key: 6, Value: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+			}}},
+			`example.com. 300 IN SVCB 16 svc.example.com. mandatory=alpn alpn="f\\,g" no-default-alpn ech=/g0= ipv6hint=2001:db8::1`,
+		},
+		{
+			DnsAnswer{Domain: "", RecordType: 65280, RecordClass: IN, TTL: 0, RawData: []byte{0xab, 0xcd}},
+			`. 0 IN TYPE65280 \# 2 abcd`,
+		},
+	}
+	for _, test := range tests {
+		if got := test.answer.Presentation(); got != test.want {
+			t.Fatalf("Got: %s, Want: %s", got, test.want)
+		}
+	}
+}
+
+func TestParseMessageType(t *testing.T) {
+	tests := []struct {
+		name string
+		want MessageType
+	}{
+		{"A", A},
+		{"mx", MX},
+		{"AAAA", AAAA},
+		{"TYPE65280", 65280},
+	}
+	for _, test := range tests {
+		got, err := ParseMessageType(test.name)
+		if err != nil || got != test.want {
+			t.Fatalf("Got: %d, %v for %s, Want: %d", got, err, test.name, test.want)
+		}
+	}
+	_, err := ParseMessageType("BOGUS")
+	if err == nil {
+		t.Fatalf("Got no error, Want: unknown type rejected")
+	}
+}
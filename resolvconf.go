@@ -0,0 +1,80 @@
+package dnsresolvr
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// NewSystemResolver returns a resolver that uses the name servers, search domains and options
+// configured in /etc/resolv.conf. When the file doesn't exist, as on systems that don't use
+// it, the resolver resolves iteratively from the root name servers.
+func NewSystemResolver() (*Resolver, error) {
+	file, err := os.Open(resolvConfPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Resolver{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	return parseResolvConf(file)
+}
+
+// parseResolvConf reads the nameserver, search and domain lines as well as the ndots,
+// timeout and attempts options of a resolv.conf file. Anything else is ignored.
+func parseResolvConf(conf io.Reader) (*Resolver, error) {
+	resolver := &Resolver{}
+	scanner := bufio.NewScanner(conf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			resolver.Servers = append(resolver.Servers, fields[1])
+		case "domain":
+			resolver.SearchDomains = []string{fields[1]}
+		case "search":
+			resolver.SearchDomains = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				name, value, _ := strings.Cut(option, ":")
+				number, err := strconv.Atoi(value)
+				if err != nil || number < 0 {
+					continue
+				}
+				switch name {
+				case "ndots":
+					resolver.NDots = number
+					if number == 0 {
+						// Zero is the default of one dot for the resolver.
+						resolver.NDots = -1
+					}
+				case "timeout":
+					resolver.Timeout = time.Duration(number) * time.Second
+				case "attempts":
+					resolver.Retries = number - 1
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
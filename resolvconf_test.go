@@ -0,0 +1,55 @@
+package dnsresolvr
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf := `# generated by NetworkManager
+domain corp.example.com
+search example.com example.org
+nameserver 192.168.1.1
+nameserver 2001:4860:4860::8888 ; secondary
+;nameserver 10.0.0.1
+options ndots:2 timeout:3 attempts:4 rotate
+`
+	resolver, err := parseResolvConf(strings.NewReader(conf))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(resolver.Servers, []string{"192.168.1.1", "2001:4860:4860::8888"}) {
+		t.Fatalf("Got servers: %v, Want: [192.168.1.1 2001:4860:4860::8888]", resolver.Servers)
+	}
+	if !slices.Equal(resolver.SearchDomains, []string{"example.com", "example.org"}) {
+		t.Fatalf("Got search domains: %v, Want: the last search line", resolver.SearchDomains)
+	}
+	if resolver.NDots != 2 || resolver.Timeout != 3*time.Second || resolver.Retries != 3 {
+		t.Fatalf("Got ndots: %d, timeout: %v, retries: %d, Want: 2, 3s, 3",
+			resolver.NDots, resolver.Timeout, resolver.Retries)
+	}
+}
+
+func TestParseResolvConfNdotsZero(t *testing.T) {
+	resolver, err := parseResolvConf(strings.NewReader("search example.com\noptions ndots:0\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := resolver.searchCandidates("foo")
+	want := []string{"foo", "foo.example.com"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Got: %v, Want: %v with ndots 0", got, want)
+	}
+}
+
+func TestParseEmptyResolvConf(t *testing.T) {
+	resolver, err := parseResolvConf(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resolver.Servers) != 0 {
+		t.Fatalf("Got servers: %v, Want: none, resolving iteratively", resolver.Servers)
+	}
+}
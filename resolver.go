@@ -0,0 +1,844 @@
+package dnsresolvr
+
+import (
+	"cmp"
+	"context"
+	"dnsresolvr/internal/pkg/utils"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultPort         = 53
+	defaultRetryBackoff = 100 * time.Millisecond
+	defaultRetryJitter  = 0.5
+	defaultMaxAnswers   = 1000
+	// maxReferrals bounds the number of queries made while walking down from the root so
+	// that referral loops between misconfigured servers terminate.
+	maxReferrals = 32
+	// maxNameServerDepth bounds how deep the resolver recurses when it has to resolve the
+	// address of a name server that came without glue.
+	maxNameServerDepth = 4
+)
+
+// Resolver resolves domain names by querying name servers. The zero value is ready to use.
+type Resolver struct {
+	// Timeout bounds every single query sent to a name server. Defaults to 5 seconds.
+	Timeout time.Duration
+	// TotalTimeout, when positive, bounds a whole resolution, including every retry, failover
+	// to another server and referral followed, after which it fails with ErrTimeout.
+	TotalTimeout time.Duration
+	// Port is the port name servers are queried on. Defaults to 53.
+	Port int
+	// QnameMinimization makes the iterative resolver send each server only the labels it
+	// needs to see to refer us further down the tree (RFC 7816) instead of the full name.
+	QnameMinimization bool
+	// Strict rejects responses that use obsolete opcodes or record types, which are parsed
+	// like any other otherwise, or that carry trailing bytes in the data of a record.
+	Strict bool
+	// Servers are the upstream name servers, given as "host" or "host:port".
+	Servers []string
+	// Clock is used wherever the resolver reads the time. Defaults to the system clock.
+	Clock Clock
+	// Cache, when set, is consulted before resolving and filled with the responses.
+	Cache *Cache
+	// RootHints are the IP addresses iterative resolution starts from. The built-in list of
+	// root name servers is used when empty.
+	RootHints []string
+	// SearchDomains are appended in turn to names that are not fully qualified, like the
+	// search list of resolv.conf.
+	SearchDomains []string
+	// NDots is the number of dots a name needs to be tried as is before the search domains
+	// are applied. Names with fewer dots are tried with the search domains first. Defaults
+	// to 1, like glibc, a negative value tries every name as is first, like ndots:0.
+	NDots int
+	// Retries is the number of times the upstream servers are queried again after all of
+	// them failed.
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled for every further retry.
+	// Defaults to 100 milliseconds.
+	RetryBackoff time.Duration
+	// RetryJitter is the fraction of each retry delay that is randomized, so that many
+	// lookups failing together don't retry in lockstep. Defaults to 0.5, a negative value
+	// disables jitter.
+	RetryJitter float64
+	// BreakerThreshold is the number of consecutive failures after which an upstream server
+	// is skipped for BreakerCooldown. Defaults to 5, a negative value never skips servers.
+	BreakerThreshold int
+	// BreakerCooldown is how long a failing upstream server is skipped. Defaults to 30
+	// seconds.
+	BreakerCooldown time.Duration
+	// DnssecOK asks servers to include DNSSEC records in their responses by setting the DO
+	// bit of an EDNS OPT record sent along with every query.
+	DnssecOK bool
+	// Cookies sends a DNS cookie (RFC 7873) with every query and rejects responses whose
+	// cookie doesn't echo it, which makes spoofing responses harder.
+	Cookies bool
+	// PaddingBlockSize, when positive, pads every query with the EDNS padding option (RFC
+	// 7830) to a multiple of that many bytes, for example the 128 RFC 8467 recommends, so
+	// that the size of encrypted queries gives away less about the name asked for.
+	PaddingBlockSize int
+	// RecursionDesired, when set, overrides the RD bit of every query, which is otherwise set
+	// for the upstream Servers and cleared when resolving iteratively.
+	RecursionDesired *bool
+	// TCP sends queries over TCP instead of UDP.
+	TCP bool
+	// DialUDP, when set, makes the UDP connections to name servers in place of net.DialUDP, for
+	// example to send queries over an existing net.PacketConn. It is called with the "udp"
+	// network and the server address.
+	DialUDP func(ctx context.Context, network, address string) (net.Conn, error)
+	// Proxy, when set, makes the TCP connections to name servers, for example the SOCKS5
+	// dialer returned by proxy.SOCKS5 from golang.org/x/net/proxy. Since UDP can't go through
+	// it, setting it sends all queries over TCP.
+	Proxy proxy.Dialer
+	// ReuseTCPConnections keeps TCP connections open after a query so that further queries
+	// to the same server don't have to connect again.
+	ReuseTCPConnections bool
+	// TCPIdleTimeout is how long an unused TCP connection is kept open for reuse, and how
+	// long a pipelined connection may go without receiving anything before it is closed.
+	// Defaults to 10 seconds.
+	TCPIdleTimeout time.Duration
+	// PipelineTCPQueries sends all TCP queries to a server over one shared connection without
+	// waiting for earlier responses (RFC 7766). It takes precedence over
+	// ReuseTCPConnections.
+	PipelineTCPQueries bool
+	// StaticHosts maps names to addresses that are returned for A and AAAA queries without
+	// asking any server, like /etc/hosts does. Names without addresses of the queried type
+	// are resolved as usual.
+	StaticHosts map[string][]string
+	// UdpPayloadSize is the largest UDP response servers are told we accept, in the EDNS OPT
+	// record. Setting it makes every query carry an OPT record. Defaults to 1232 bytes and
+	// must be at least 512.
+	UdpPayloadSize uint16
+	// ReadBufferSize is the size of the buffer UDP responses are read into, anything beyond
+	// it is lost. Defaults to the payload size and must lie within [512, 65535].
+	ReadBufferSize int
+	// DumpDir, when set, is a directory every query and the raw response it got are written
+	// to, for replaying or debugging them later. Failing to write them doesn't fail the
+	// resolution, the exchange just goes missing from the directory.
+	DumpDir string
+	// Transport, when set, sends every query in place of the built-in UDP and TCP transports,
+	// for example a ReplayTransport in tests.
+	Transport Transport
+	// SingleFlight makes concurrent lookups of the same name and type share a single
+	// resolution, and its result, instead of each querying the servers.
+	SingleFlight bool
+	// MaxAnswers is the number of records kept from each section of a response, any further
+	// records are dropped so that a hostile server can't make the resolver hold on to huge
+	// responses. Defaults to 1000.
+	MaxAnswers int
+	// SortAnswers sorts the answers of every response by type and then record data, so that
+	// the order doesn't depend on how the server happened to rotate them.
+	SortAnswers bool
+
+	mu            sync.Mutex
+	breakers      map[string]*circuitBreaker
+	clientCookie  []byte
+	serverCookies map[string][]byte
+	idleConns     map[string][]idleConn
+	pipelines     map[string]*pipelinedConn
+	pipelineDials map[string]*pipelineDial
+	flights       map[cacheKey]*flight
+	// refreshCtx is the context of the cache refreshes running in the background, which Close
+	// cancels with cancelRefreshes before waiting for them to stop.
+	refreshCtx      context.Context
+	cancelRefreshes context.CancelFunc
+	refreshes       sync.WaitGroup
+}
+
+func (r *Resolver) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return r.Timeout
+}
+
+// resolveContext returns the context a resolution runs in, bounded by TotalTimeout.
+func (r *Resolver) resolveContext() (context.Context, context.CancelFunc) {
+	if r.TotalTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.TotalTimeout)
+}
+
+func (r *Resolver) port() int {
+	if r.Port <= 0 {
+		return defaultPort
+	}
+	return r.Port
+}
+
+// retryBackoff returns how long to wait before the given retry, counting from zero.
+func (r *Resolver) retryBackoff(retry int) time.Duration {
+	backoff := r.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	backoff <<= min(retry, 16)
+	jitter := r.RetryJitter
+	if jitter == 0 {
+		jitter = defaultRetryJitter
+	}
+	if jitter > 0 {
+		backoff -= time.Duration(float64(backoff) * min(jitter, 1) * utils.GetRandomFloat64())
+	}
+	return backoff
+}
+
+func (r *Resolver) clock() Clock {
+	if r.Clock == nil {
+		return realClock{}
+	}
+	return r.Clock
+}
+
+func (r *Resolver) parseResponse(response []byte) (*DnsResponse, error) {
+	return parseResponseWithOptions(response, parseOptions{strict: r.Strict, now: r.clock().Now, maxRecords: r.maxAnswers()})
+}
+
+func (r *Resolver) maxAnswers() int {
+	if r.MaxAnswers <= 0 {
+		return defaultMaxAnswers
+	}
+	return r.MaxAnswers
+}
+
+// serverAddress returns the address to dial for server, adding the resolver's port when the
+// server doesn't specify one. IPv6 addresses may be given bare, in brackets, or in brackets
+// with a port.
+func (r *Resolver) serverAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(r.port()))
+}
+
+func (r *Resolver) rootServers() ([]string, error) {
+	if len(r.RootHints) == 0 {
+		return rootNameServers, nil
+	}
+	for _, hint := range r.RootHints {
+		if net.ParseIP(hint) == nil {
+			return nil, fmt.Errorf("invalid root hint %q: not an IP address", hint)
+		}
+	}
+	return r.RootHints, nil
+}
+
+// newQuery builds a query for domainName to be sent to server. It carries an OPT record when
+// any EDNS option is enabled.
+func (r *Resolver) newQuery(server string, domainName string, qtype MessageType) *DnsQuery {
+	query := generateDnsQuery(domainName, qtype)
+	query.Header.IsRecursionDesired = r.recursionDesired(false)
+	if r.DnssecOK || r.Cookies || r.UdpPayloadSize != 0 || r.PaddingBlockSize > 0 {
+		query.Edns = &Edns{UdpPayloadSize: r.udpPayloadSize(), DnssecOK: r.DnssecOK}
+		r.setEdnsOptions(query, server)
+	}
+	return query
+}
+
+// setEdnsOptions sets the cookie and padding options of a query carrying an OPT record.
+func (r *Resolver) setEdnsOptions(query *DnsQuery, server string) {
+	query.Edns.Options = nil
+	if r.Cookies {
+		query.Edns.Options = append(query.Edns.Options, r.cookieOption(server))
+	}
+	if r.PaddingBlockSize > 0 {
+		query.Edns.Options = append(query.Edns.Options, paddingOption(len(query.GetBytes()), r.PaddingBlockSize))
+	}
+}
+
+// recursionDesired returns the RD bit for a query, fallback unless RecursionDesired is set.
+func (r *Resolver) recursionDesired(fallback bool) bool {
+	if r.RecursionDesired == nil {
+		return fallback
+	}
+	return *r.RecursionDesired
+}
+
+// exchange sends query to server and parses the response. A server that answers a query
+// carrying an OPT record with FORMERR may predate EDNS (RFC 6891 section 7), so it is asked
+// once more without the record. A server that answers BADCOOKIE has handed out a new server
+// cookie, so it is asked once more with that cookie (RFC 7873 section 5.3).
+func (r *Resolver) exchange(ctx context.Context, query *DnsQuery, server string) (*DnsResponse, error) {
+	response, err := r.exchangeOnce(ctx, query, server)
+	if err != nil || query.Edns == nil {
+		return response, err
+	}
+	switch response.extendedResponseCode() {
+	case int(FormatError):
+		plainQuery := *query
+		plainQuery.Edns = nil
+		return r.exchangeOnce(ctx, &plainQuery, server)
+	case badCookie:
+		if !r.Cookies {
+			return response, nil
+		}
+		cookieQuery := *query
+		edns := *query.Edns
+		cookieQuery.Edns = &edns
+		r.setEdnsOptions(&cookieQuery, server)
+		return r.exchangeOnce(ctx, &cookieQuery, server)
+	}
+	return response, nil
+}
+
+func (r *Resolver) exchangeOnce(ctx context.Context, query *DnsQuery, server string) (*DnsResponse, error) {
+	err := r.checkBufferSizes()
+	if err != nil {
+		return nil, err
+	}
+	err = query.Validate()
+	if err != nil {
+		return nil, err
+	}
+	address := r.serverAddress(server)
+	var responseBytes []byte
+	if r.Transport != nil {
+		responseBytes, err = r.Transport.Exchange(ctx, query, address)
+	} else if r.TCP || r.Proxy != nil {
+		responseBytes, err = r.queryTCP(ctx, query, address)
+	} else {
+		dial := r.DialUDP
+		if dial == nil {
+			dial = dialUDP
+		}
+		responseBytes, err = queryDnsWithDialer(ctx, dial, query, address, r.timeout(), r.readBufferSize())
+	}
+	if err != nil {
+		if isTimeout(err) {
+			return nil, wrapError(ErrTimeout, err)
+		}
+		return nil, err
+	}
+	if r.DumpDir != "" {
+		_ = r.dumpExchange(query, responseBytes)
+	}
+	response, err := r.parseResponse(responseBytes)
+	if err != nil {
+		if r.Transport == nil && !r.TCP && r.Proxy == nil && len(responseBytes) == r.readBufferSize() {
+			return nil, fmt.Errorf("%w: response filled the %d byte read buffer: %w", ErrTruncated, len(responseBytes), err)
+		}
+		return nil, err
+	}
+	response.Server = address
+	if r.Cookies {
+		err = r.checkServerCookie(server, query, response)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// Close releases what the resolver keeps between queries: pooled TCP connections and the
+// state it learned about each server. Cache refreshes running in the background are stopped
+// first, so that they can't open new connections afterwards. It is safe to call more than
+// once and the resolver can be used again afterwards.
+func (r *Resolver) Close() error {
+	r.mu.Lock()
+	cancelRefreshes := r.cancelRefreshes
+	r.refreshCtx, r.cancelRefreshes = nil, nil
+	r.mu.Unlock()
+	if cancelRefreshes != nil {
+		cancelRefreshes()
+	}
+	r.refreshes.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakers = nil
+	r.serverCookies = nil
+	return r.closeIdleConns()
+}
+
+// Ping checks that the upstream servers, or the root servers when there are none, are
+// responsive by asking for the name servers of the root zone. It returns nil as soon as one
+// server sends back a valid response.
+func (r *Resolver) Ping(ctx context.Context) error {
+	servers := r.Servers
+	if len(servers) == 0 {
+		rootServers, err := r.rootServers()
+		if err != nil {
+			return err
+		}
+		servers = rootServers
+	}
+	var lastErr error
+	for _, server := range servers {
+		response, err := r.exchange(ctx, r.newQuery(server, "", NS), server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if response.Header.ResponseCode != NoError {
+			lastErr = fmt.Errorf("server %s responded with code %d", server, response.Header.ResponseCode)
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// Resolve resolves the IPv4 addresses of domainName, which must be a valid host name. Names
+// that are not fully qualified are also tried with each of the SearchDomains appended, see
+// NDots for the order. The first name that resolves is returned, otherwise the result for
+// the name as given.
+func (r *Resolver) Resolve(domainName string) (*DnsResponse, error) {
+	if !IsValidHostname(domainName) {
+		return nil, fmt.Errorf("invalid host name %q", domainName)
+	}
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	var response *DnsResponse
+	var err error
+	for _, candidate := range r.searchCandidates(domainName) {
+		candidateResponse, candidateErr := r.resolveType(ctx, candidate, A)
+		if isResolved(candidateResponse, candidateErr) {
+			return candidateResponse, nil
+		}
+		if candidate == domainName {
+			response, err = candidateResponse, candidateErr
+		}
+	}
+	return response, err
+}
+
+func (r *Resolver) ndots() int {
+	if r.NDots == 0 {
+		return 1
+	}
+	return max(r.NDots, 0)
+}
+
+// searchCandidates lists the names to try for domainName in order. A fully qualified name,
+// one with a trailing dot, is only tried as is. Other names are tried as is first when they
+// have at least NDots dots, and after the search domains otherwise.
+func (r *Resolver) searchCandidates(domainName string) []string {
+	if strings.HasSuffix(domainName, ".") {
+		return []string{domainName}
+	}
+	var searchNames []string
+	for _, searchDomain := range r.SearchDomains {
+		searchNames = append(searchNames, domainName+"."+strings.Trim(searchDomain, "."))
+	}
+	if strings.Count(domainName, ".") >= r.ndots() {
+		return append([]string{domainName}, searchNames...)
+	}
+	return append(searchNames, domainName)
+}
+
+// isResolved reports whether a resolution succeeded with at least one answer.
+func isResolved(response *DnsResponse, err error) bool {
+	return err == nil && response.Header.ResponseCode == NoError && len(response.Answers) != 0
+}
+
+// ResolveType resolves records of type qtype for domainName. qtype can also be one of the
+// query-only types ANY_TYPE, MAILA or MAILB, see MessageType.Matches. When Servers are
+// configured they are asked to recurse on our behalf, failing over from one to the next.
+// Otherwise the name is resolved iteratively from the root.
+//
+// The full response is returned. Unless TCP or Proxy is set queries are sent over UDP, so
+// when the answer didn't fit the server sets Header.IsTruncatedMessage and the answers may
+// be incomplete. Errors are returned as a *DNSError. An NXDOMAIN or SERVFAIL response is
+// returned along with one wrapping ErrNameError or ErrServerFailure.
+func (r *Resolver) ResolveType(domainName string, qtype MessageType) (*DnsResponse, error) {
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	return r.resolveType(ctx, domainName, qtype)
+}
+
+func (r *Resolver) resolveType(ctx context.Context, domainName string, qtype MessageType) (*DnsResponse, error) {
+	domainName = strings.TrimSuffix(domainName, ".")
+	if len(r.Servers) == 0 {
+		return r.resolveCached(ctx, domainName, qtype, func(ctx context.Context) (*DnsResponse, error) {
+			return r.resolveIterative(ctx, domainName, qtype, 0)
+		})
+	}
+	return r.resolveCached(ctx, domainName, qtype, func(ctx context.Context) (*DnsResponse, error) {
+		return r.resolveUpstream(ctx, domainName, qtype)
+	})
+}
+
+// ResolveRaw is ResolveType that also returns the message the response was parsed from,
+// exactly as the name server sent it, for example to verify signatures over it. The bytes
+// are nil for responses that were not received from a server, such as ones from StaticHosts.
+func (r *Resolver) ResolveRaw(domainName string, qtype MessageType) (*DnsResponse, []byte, error) {
+	response, err := r.ResolveType(domainName, qtype)
+	if response == nil {
+		return nil, nil, err
+	}
+	return response, response.raw, err
+}
+
+// ResolveAll resolves both the IPv4 and the IPv6 addresses of domainName and returns them in
+// a single response, the A records first.
+func (r *Resolver) ResolveAll(domainName string) (*DnsResponse, error) {
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	ipv4, err := r.resolveType(ctx, domainName, A)
+	if err != nil {
+		return ipv4, err
+	}
+	ipv6, err := r.resolveType(ctx, domainName, AAAA)
+	if err != nil {
+		return ipv6, err
+	}
+	return mergeResponses(ipv4, ipv6), nil
+}
+
+// ResolveIterative resolves domainName starting at the root name servers and following
+// referrals until a server answers authoritatively.
+func (r *Resolver) ResolveIterative(domainName string, qtype MessageType) (*DnsResponse, error) {
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	domainName = strings.TrimSuffix(domainName, ".")
+	return r.resolveCached(ctx, domainName, qtype, func(ctx context.Context) (*DnsResponse, error) {
+		return r.resolveIterative(ctx, domainName, qtype, 0)
+	})
+}
+
+// resolveCached answers from StaticHosts or the cache when possible and caches what resolve
+// returns when run in ctx. Errors are reported as a DNSError, for error response codes next
+// to the response.
+//
+// Responses from the cache and from a shared resolution are handed to several callers, so
+// their answers are sorted once, before they are shared, and never modified afterwards.
+func (r *Resolver) resolveCached(ctx context.Context, domainName string, qtype MessageType, resolve func(context.Context) (*DnsResponse, error)) (*DnsResponse, error) {
+	_, err := splitDomainLabels(domainName)
+	if err != nil {
+		return nil, &DNSError{Question: domainName, Qtype: qtype, Err: err}
+	}
+	if r.SortAnswers {
+		resolve = sortedResolve(resolve)
+	}
+	if response, ok := r.resolveStatic(domainName, qtype); ok {
+		if r.SortAnswers {
+			sortAnswers(response.Answers)
+		}
+		return response, nil
+	}
+	if r.Cache != nil {
+		if response, ok, refresh := r.Cache.lookup(domainName, qtype, IN); ok {
+			if refresh {
+				r.startRefresh(resolve)
+			}
+			return response, nil
+		}
+	}
+	var response *DnsResponse
+	if r.SingleFlight {
+		response, err = r.resolveShared(ctx, domainName, qtype, resolve)
+	} else {
+		response, err = resolve(ctx)
+	}
+	if err != nil {
+		return nil, &DNSError{Question: domainName, Qtype: qtype, Err: err}
+	}
+	if r.Cache != nil {
+		r.Cache.Put(response)
+	}
+	return response, responseError(domainName, qtype, response)
+}
+
+// sortedResolve wraps resolve to sort the answers of the responses it returns.
+func sortedResolve(resolve func(context.Context) (*DnsResponse, error)) func(context.Context) (*DnsResponse, error) {
+	return func(ctx context.Context) (*DnsResponse, error) {
+		response, err := resolve(ctx)
+		if err == nil {
+			sortAnswers(response.Answers)
+		}
+		return response, err
+	}
+}
+
+// sortAnswers orders answers by type, then by record data in presentation format and last
+// by owner name.
+func sortAnswers(answers []DnsAnswer) {
+	slices.SortStableFunc(answers, func(a, b DnsAnswer) int {
+		if a.RecordType != b.RecordType {
+			return cmp.Compare(a.RecordType, b.RecordType)
+		}
+		if rdataCompare := strings.Compare(a.RdataPresentation(), b.RdataPresentation()); rdataCompare != 0 {
+			return rdataCompare
+		}
+		return strings.Compare(strings.ToLower(a.Domain), strings.ToLower(b.Domain))
+	})
+}
+
+// startRefresh runs refreshCached in the background, where Close can stop it.
+func (r *Resolver) startRefresh(resolve func(context.Context) (*DnsResponse, error)) {
+	r.mu.Lock()
+	if r.refreshCtx == nil {
+		r.refreshCtx, r.cancelRefreshes = context.WithCancel(context.Background())
+	}
+	refreshCtx := r.refreshCtx
+	r.refreshes.Add(1)
+	r.mu.Unlock()
+	go func() {
+		defer r.refreshes.Done()
+		r.refreshCached(refreshCtx, resolve)
+	}()
+}
+
+// refreshCached resolves a cached question again and caches the result, unless refreshCtx is
+// done first.
+func (r *Resolver) refreshCached(refreshCtx context.Context, resolve func(context.Context) (*DnsResponse, error)) {
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	stop := context.AfterFunc(refreshCtx, cancel)
+	defer stop()
+	response, err := resolve(ctx)
+	if err != nil {
+		return
+	}
+	r.Cache.Put(response)
+}
+
+// resolveUpstream queries the upstream servers, retrying with backoff when all of them fail.
+func (r *Resolver) resolveUpstream(ctx context.Context, domainName string, qtype MessageType) (*DnsResponse, error) {
+	for retry := 0; ; retry++ {
+		response, err := r.queryUpstreamServers(ctx, domainName, qtype)
+		if err == nil || retry >= r.Retries {
+			return response, err
+		}
+		select {
+		case <-time.After(r.retryBackoff(retry)):
+		case <-ctx.Done():
+			return nil, wrapError(ErrTimeout, ctx.Err())
+		}
+	}
+}
+
+// queryUpstreamServers asks the upstream servers in turn for a recursive answer. A server
+// that fails, or reports a server failure or refusal, is skipped in favour of the next one.
+// The last such refusal is returned when no server does better. Servers whose circuit
+// breaker is open are not queried at all.
+func (r *Resolver) queryUpstreamServers(ctx context.Context, domainName string, qtype MessageType) (*DnsResponse, error) {
+	var lastResponse *DnsResponse
+	lastErr := errors.New("all upstream servers are unavailable")
+	for _, server := range r.Servers {
+		if ctx.Err() != nil {
+			return nil, wrapError(ErrTimeout, ctx.Err())
+		}
+		if !r.isServerAvailable(server) {
+			continue
+		}
+		query := r.newQuery(server, domainName, qtype)
+		query.Header.IsRecursionDesired = r.recursionDesired(true)
+		response, err := r.exchange(ctx, query, server)
+		if err != nil {
+			r.recordServerResult(server, true)
+			lastErr = err
+			continue
+		}
+		rcode := response.Header.ResponseCode
+		if rcode == ServerFailure || rcode == Refused {
+			r.recordServerResult(server, true)
+			lastResponse = response
+			continue
+		}
+		r.recordServerResult(server, false)
+		return response, nil
+	}
+	if lastResponse != nil {
+		return lastResponse, nil
+	}
+	return nil, lastErr
+}
+
+func (r *Resolver) resolveIterative(ctx context.Context, domainName string, qtype MessageType, depth int) (*DnsResponse, error) {
+	if depth > maxNameServerDepth {
+		return nil, errors.New("name server resolution nested too deeply")
+	}
+	servers, err := r.rootServers()
+	if err != nil {
+		return nil, err
+	}
+	labels, err := splitDomainLabels(domainName)
+	if err != nil {
+		return nil, err
+	}
+	zone := ""
+	minimizedLabels := 0
+	for i := 0; i < maxReferrals; i++ {
+		qname, queryType := domainName, qtype
+		if r.QnameMinimization {
+			minimizedLabels = max(minimizedLabels, countLabels(zone)+1)
+			if minimizedLabels < len(labels) {
+				qname = strings.Join(labels[len(labels)-minimizedLabels:], ".")
+				queryType = NS
+			}
+		}
+		response, err := r.queryNameServers(ctx, servers, qname, queryType, zone)
+		if err != nil {
+			return nil, err
+		}
+		discardOutOfBailiwickRecords(response, zone)
+		referralZone, nameServers := findReferral(response, qname, zone)
+		if referralZone == "" {
+			if qname != domainName {
+				// The minimized name is not a zone cut, or the server didn't like it. Either
+				// way the same servers are asked again with one more label.
+				minimizedLabels++
+				continue
+			}
+			return response, nil
+		}
+		servers, err = r.resolveNameServerAddresses(ctx, response, nameServers, depth)
+		if err != nil {
+			return nil, err
+		}
+		zone = referralZone
+	}
+	return nil, errors.New("too many referrals while resolving " + domainName)
+}
+
+// queryNameServers sends the query to each of the servers for zone in turn and returns the
+// first usable response. Servers that fail or turn out to be lame are skipped.
+func (r *Resolver) queryNameServers(ctx context.Context, servers []string, domainName string, qtype MessageType, zone string) (*DnsResponse, error) {
+	var lastErr error
+	lameServers := 0
+	for _, server := range servers {
+		if ctx.Err() != nil {
+			return nil, wrapError(ErrTimeout, ctx.Err())
+		}
+		response, err := r.exchange(ctx, r.newQuery(server, domainName, qtype), server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if isLameResponse(response, domainName, zone) {
+			lameServers++
+			continue
+		}
+		return response, nil
+	}
+	if lameServers != 0 && lameServers == len(servers) {
+		return nil, fmt.Errorf("all name servers for zone %q are lame", zone)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no name servers to query")
+	}
+	return nil, lastErr
+}
+
+// findReferral returns the zone a response delegates to along with the names of its name
+// servers. Only delegations to a zone below currentZone that contains qname are accepted, so
+// the walk always makes progress down the tree.
+func findReferral(response *DnsResponse, qname string, currentZone string) (string, []string) {
+	if len(response.Answers) != 0 || response.Header.ResponseCode != NoError {
+		return "", nil
+	}
+	zone := ""
+	var nameServers []string
+	for _, record := range response.NameServerRecords {
+		if record.RecordType != NS || !isSubdomain(qname, record.Domain) {
+			continue
+		}
+		if countLabels(record.Domain) <= countLabels(currentZone) || !isSubdomain(record.Domain, currentZone) {
+			continue
+		}
+		if zone != "" && !strings.EqualFold(zone, record.Domain) {
+			continue
+		}
+		zone = record.Domain
+		nameServers = append(nameServers, record.Target)
+	}
+	return zone, nameServers
+}
+
+// isLameResponse reports whether a server we were referred to as authoritative for zone gave
+// neither an authoritative answer nor a referral further down (a lame delegation).
+func isLameResponse(response *DnsResponse, qname string, zone string) bool {
+	if response.Header.IsAuthoritativeAnswer || len(response.Answers) != 0 {
+		return false
+	}
+	referralZone, _ := findReferral(response, qname, zone)
+	return referralZone == ""
+}
+
+// discardOutOfBailiwickRecords drops the records of a response whose owner names are not
+// within zone, the zone the responding server was asked about. A server has no authority over
+// such records, so accepting them would allow it to poison answers for unrelated names.
+func discardOutOfBailiwickRecords(response *DnsResponse, zone string) {
+	inBailiwick := func(records []DnsAnswer) []DnsAnswer {
+		var kept []DnsAnswer
+		for _, record := range records {
+			if isSubdomain(record.Domain, zone) {
+				kept = append(kept, record)
+			}
+		}
+		return kept
+	}
+	response.Answers = inBailiwick(response.Answers)
+	response.NameServerRecords = inBailiwick(response.NameServerRecords)
+	response.AdditionalRecords = inBailiwick(response.AdditionalRecords)
+	response.Header.AnswerCount = uint16(len(response.Answers))
+	response.Header.NameServerRecordsCount = uint16(len(response.NameServerRecords))
+	response.Header.AdditionalRecordsCount = uint16(len(response.AdditionalRecords))
+}
+
+// resolveNameServerAddresses looks up IPv4 addresses of the name servers, preferring glue
+// records from the additional section and resolving the names only when there is no glue.
+func (r *Resolver) resolveNameServerAddresses(ctx context.Context, response *DnsResponse, nameServers []string, depth int) ([]string, error) {
+	var addresses []string
+	for _, nameServer := range nameServers {
+		for _, record := range response.AdditionalRecords {
+			if record.RecordType == A && strings.EqualFold(record.Domain, nameServer) {
+				addresses = append(addresses, record.Address)
+			}
+		}
+	}
+	if len(addresses) != 0 {
+		return addresses, nil
+	}
+	var lastErr error
+	for _, nameServer := range nameServers {
+		nsResponse, err := r.resolveIterative(ctx, nameServer, A, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, record := range nsResponse.Answers {
+			if record.RecordType == A {
+				addresses = append(addresses, record.Address)
+			}
+		}
+		if len(addresses) != 0 {
+			return addresses, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no addresses found for name servers")
+	}
+	return nil, lastErr
+}
+
+// isSubdomain reports whether name is equal to or below zone. Every name is below the root
+// zone, which is represented by an empty string.
+func isSubdomain(name string, zone string) bool {
+	if zone == "" {
+		return true
+	}
+	name = strings.ToLower(name)
+	zone = strings.ToLower(zone)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func countLabels(domainName string) int {
+	if domainName == "" {
+		return 0
+	}
+	return strings.Count(domainName, ".") + 1
+}
@@ -0,0 +1,829 @@
+package dnsresolvr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockHierarchy serves www.example.com through a root at 127.0.0.1, a com server at
+// 127.0.0.2 and an example.com server at 127.0.0.3, all sharing the same port. The com server
+// refers to one example.com name server per address in exampleAddresses, defaulting to the
+// mock example.com server.
+type mockHierarchy struct {
+	port    int
+	root    *mockServer
+	com     *mockServer
+	example *mockServer
+}
+
+func startMockHierarchy(t *testing.T, exampleAddresses ...string) *mockHierarchy {
+	t.Helper()
+	if len(exampleAddresses) == 0 {
+		exampleAddresses = []string{"127.0.0.3"}
+	}
+	var exampleNameServers, exampleGlue []testRecord
+	for i, address := range exampleAddresses {
+		nameServer := "ns" + strconv.Itoa(i+1) + ".example.com"
+		exampleNameServers = append(exampleNameServers, nsRecord("example.com", nameServer))
+		exampleGlue = append(exampleGlue, aRecord(nameServer, address))
+	}
+	port := freeMockPort(t)
+	hierarchy := &mockHierarchy{port: port}
+	hierarchy.root = startMockServer(t, mockAddress("127.0.0.1", port), func(query *DnsResponse) []byte {
+		return testReply{
+			authorities: []testRecord{nsRecord("com", "ns.com")},
+			additionals: []testRecord{aRecord("ns.com", "127.0.0.2")},
+		}.bytesFor(query)
+	})
+	hierarchy.com = startMockServer(t, mockAddress("127.0.0.2", port), func(query *DnsResponse) []byte {
+		return testReply{
+			authorities: exampleNameServers,
+			additionals: exampleGlue,
+		}.bytesFor(query)
+	})
+	hierarchy.example = startMockServer(t, mockAddress("127.0.0.3", port), func(query *DnsResponse) []byte {
+		reply := testReply{authoritative: true}
+		if query.Question.GetDomainName() == "www.example.com" && query.Question.Qtype == A {
+			reply.answers = []testRecord{aRecord("www.example.com", "93.184.216.34")}
+		}
+		return reply.bytesFor(query)
+	})
+	return hierarchy
+}
+
+// newResolver returns a resolver that uses the mock root as its only root hint.
+func (h *mockHierarchy) newResolver() *Resolver {
+	return &Resolver{Port: h.port, RootHints: []string{"127.0.0.1"}}
+}
+
+func receivedQnames(server *mockServer) []string {
+	var qnames []string
+	for _, query := range server.receivedQueries() {
+		qnames = append(qnames, query.Question.GetDomainName())
+	}
+	return qnames
+}
+
+func TestResolveIterative(t *testing.T) {
+	hierarchy := startMockHierarchy(t)
+	resolver := hierarchy.newResolver()
+	response, err := resolver.ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+	for _, server := range []*mockServer{hierarchy.root, hierarchy.com, hierarchy.example} {
+		got := receivedQnames(server)
+		if !slices.Equal(got, []string{"www.example.com"}) {
+			t.Fatalf("Server %s got: %v, Want: [www.example.com]", server.address(), got)
+		}
+	}
+}
+
+func TestResolveIterativeWithQnameMinimization(t *testing.T) {
+	hierarchy := startMockHierarchy(t)
+	resolver := hierarchy.newResolver()
+	resolver.QnameMinimization = true
+	response, err := resolver.ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+	tests := []struct {
+		server *mockServer
+		qname  string
+		qtype  MessageType
+	}{
+		{hierarchy.root, "com", NS},
+		{hierarchy.com, "example.com", NS},
+		{hierarchy.example, "www.example.com", A},
+	}
+	for _, test := range tests {
+		queries := test.server.receivedQueries()
+		if len(queries) != 1 {
+			t.Fatalf("Server %s got %d queries, Want: 1", test.server.address(), len(queries))
+		}
+		question := queries[0].Question
+		if !strings.EqualFold(question.GetDomainName(), test.qname) || question.Qtype != test.qtype {
+			t.Fatalf("Server %s got: %s (%d), Want: %s (%d)", test.server.address(),
+				question.GetDomainName(), question.Qtype, test.qname, test.qtype)
+		}
+	}
+}
+
+func TestResolveIterativeSkipsLameNameServers(t *testing.T) {
+	hierarchy := startMockHierarchy(t, "127.0.0.4", "127.0.0.3")
+	lame := startMockServer(t, mockAddress("127.0.0.4", hierarchy.port), func(query *DnsResponse) []byte {
+		return testReply{}.bytesFor(query)
+	})
+	resolver := hierarchy.newResolver()
+	response, err := resolver.ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+	if len(lame.receivedQueries()) != 1 {
+		t.Fatalf("Lame server got %d queries, Want: 1", len(lame.receivedQueries()))
+	}
+}
+
+func TestResolveIterativeFailsWhenAllNameServersAreLame(t *testing.T) {
+	hierarchy := startMockHierarchy(t, "127.0.0.4")
+	startMockServer(t, mockAddress("127.0.0.4", hierarchy.port), func(query *DnsResponse) []byte {
+		return testReply{rcode: Refused}.bytesFor(query)
+	})
+	resolver := hierarchy.newResolver()
+	_, err := resolver.ResolveIterative("www.example.com", A)
+	if err == nil || !strings.Contains(err.Error(), "lame") {
+		t.Fatalf("Got error: %v, Want: lame delegation error", err)
+	}
+}
+
+func TestResolveIterativeWithInvalidRootHint(t *testing.T) {
+	resolver := &Resolver{RootHints: []string{"127.0.0.1", "a.root-servers.net"}}
+	_, err := resolver.ResolveIterative("www.example.com", A)
+	if err == nil || !strings.Contains(err.Error(), "invalid root hint") {
+		t.Fatalf("Got error: %v, Want: invalid root hint error", err)
+	}
+}
+
+func TestResolveIterativeDiscardsOutOfBailiwickRecords(t *testing.T) {
+	hierarchy := startMockHierarchy(t, "127.0.0.4")
+	startMockServer(t, mockAddress("127.0.0.4", hierarchy.port), func(query *DnsResponse) []byte {
+		return testReply{
+			authoritative: true,
+			answers: []testRecord{
+				aRecord("www.example.com", "93.184.216.34"),
+				aRecord("www.bank.org", "6.6.6.6"),
+			},
+			additionals: []testRecord{aRecord("ns.bank.org", "6.6.6.7")},
+		}.bytesFor(query)
+	})
+	response, err := hierarchy.newResolver().ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Domain != "www.example.com" {
+		t.Fatalf("Got answers: %v, Want: only www.example.com", response.Answers)
+	}
+	if response.Header.AnswerCount != 1 || len(response.AdditionalRecords) != 0 {
+		t.Fatalf("Got %d answers and additional records %v, Want: 1 answer and none",
+			response.Header.AnswerCount, response.AdditionalRecords)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{authoritative: true}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	err := resolver.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	queries := server.receivedQueries()
+	if len(queries) != 1 || queries[0].Question.GetDomainName() != "" || queries[0].Question.Qtype != NS {
+		t.Fatalf("Got queries: %v, Want: a single root NS query", queries)
+	}
+}
+
+func TestPingBlackhole(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return nil
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := resolver.Ping(ctx)
+	if err == nil {
+		t.Fatalf("Got no error, Want: timeout")
+	}
+}
+
+func TestResolveTypeQueriesUpstreamServers(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.Resolve("www.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+	if !server.receivedQueries()[0].Header.IsRecursionDesired {
+		t.Fatalf("Got query without RD bit, Want: recursion desired from upstream servers")
+	}
+}
+
+func TestResolveTypeRetriesUpstreamServers(t *testing.T) {
+	var received atomic.Int32
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		if received.Add(1) == 1 {
+			return nil
+		}
+		return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	resolver := &Resolver{
+		Servers:      []string{server.address()},
+		Timeout:      100 * time.Millisecond,
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+	}
+	response, err := resolver.Resolve("www.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || received.Load() != 2 {
+		t.Fatalf("Got %d answers after %d queries, Want: 1 answer after 2 queries", len(response.Answers), received.Load())
+	}
+}
+
+func TestTotalTimeoutBoundsRetries(t *testing.T) {
+	var received atomic.Int32
+	drop := func(query *DnsResponse) []byte {
+		received.Add(1)
+		return nil
+	}
+	first := startMockServer(t, "127.0.0.1:0", drop)
+	second := startMockServer(t, "127.0.0.1:0", drop)
+	resolver := &Resolver{
+		Servers:      []string{first.address(), second.address()},
+		Timeout:      100 * time.Millisecond,
+		Retries:      3,
+		RetryBackoff: 50 * time.Millisecond,
+		TotalTimeout: 250 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := resolver.Resolve("www.example.com")
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Got error: %v, Want: ErrTimeout", err)
+	}
+	if elapsed > 400*time.Millisecond {
+		t.Fatalf("Got resolution taking %v, Want: about 250ms", elapsed)
+	}
+	if received.Load() >= 8 {
+		t.Fatalf("Got %d queries, Want: fewer than the 8 attempts without a total timeout", received.Load())
+	}
+}
+
+func TestSortAnswersIsStableAcrossRotations(t *testing.T) {
+	var received atomic.Int32
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		records := []testRecord{
+			aRecord("www.example.com", "192.0.2.3"),
+			aRecord("www.example.com", "192.0.2.1"),
+			cnameRecord("www.example.com", "web.example.com"),
+			aRecord("www.example.com", "192.0.2.2"),
+		}
+		rotation := int(received.Add(1)) % len(records)
+		return testReply{answers: append(records[rotation:], records[:rotation]...)}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, SortAnswers: true}
+	var orders []string
+	for i := 0; i < 4; i++ {
+		response, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var order []string
+		for _, answer := range response.Answers {
+			order = append(order, answer.RdataPresentation())
+		}
+		orders = append(orders, strings.Join(order, " "))
+	}
+	want := "192.0.2.1 192.0.2.2 192.0.2.3 web.example.com."
+	for _, order := range orders {
+		if order != want {
+			t.Fatalf("Got orders: %q, Want: %q every time", orders, want)
+		}
+	}
+}
+
+func TestSortAnswersOfSharedResponses(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		time.Sleep(50 * time.Millisecond)
+		return testReply{answers: []testRecord{
+			aRecord("www.example.com", "192.0.2.2"),
+			aRecord("www.example.com", "192.0.2.1"),
+		}}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, SortAnswers: true, SingleFlight: true, Cache: &Cache{}}
+	errs := make(chan error, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := resolver.ResolveType("www.example.com", A)
+			if err == nil && (len(response.Answers) != 2 || response.Answers[0].Address != "192.0.2.1") {
+				err = fmt.Errorf("got answers: %v", response.Answers)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestRetryBackoffIsJittered(t *testing.T) {
+	resolver := &Resolver{RetryBackoff: 100 * time.Millisecond, RetryJitter: 0.5}
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		delay := resolver.retryBackoff(2)
+		if delay < 200*time.Millisecond || delay > 400*time.Millisecond {
+			t.Fatalf("Got delay: %v, Want: between 200ms and 400ms", delay)
+		}
+		delays[delay] = true
+	}
+	if len(delays) < 90 {
+		t.Fatalf("Got %d distinct delays out of 100, Want: delays spread by jitter", len(delays))
+	}
+	resolver.RetryJitter = -1
+	if delay := resolver.retryBackoff(2); delay != 400*time.Millisecond {
+		t.Fatalf("Got delay: %v, Want: 400ms without jitter", delay)
+	}
+}
+
+func TestResolveRejectsInvalidHostname(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}}
+	_, err := resolver.Resolve("www.exa_mple.com")
+	if err == nil {
+		t.Fatalf("Got no error, Want: invalid host name rejected")
+	}
+	if len(server.receivedQueries()) != 0 {
+		t.Fatalf("Got %d queries, Want: none for an invalid host name", len(server.receivedQueries()))
+	}
+}
+
+func TestResolveAppliesSearchDomains(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		if query.Question.GetDomainName() != "foo.example.com" {
+			return testReply{rcode: NameError}.bytesFor(query)
+		}
+		return testReply{answers: []testRecord{aRecord("foo.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	resolver := &Resolver{
+		Servers:       []string{server.address()},
+		SearchDomains: []string{"example.org", "example.com", "example.net"},
+	}
+	response, err := resolver.Resolve("foo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Question.GetDomainName() != "foo.example.com" || len(response.Answers) != 1 {
+		t.Fatalf("Got: %s with %d answers, Want: foo.example.com with 1 answer",
+			response.Question.GetDomainName(), len(response.Answers))
+	}
+	got := receivedQnames(server)
+	want := []string{"foo.example.org", "foo.example.com"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Got queries: %v, Want: %v", got, want)
+	}
+}
+
+func TestSearchCandidatesHonourNDots(t *testing.T) {
+	resolver := &Resolver{SearchDomains: []string{"example.com", "example.org."}}
+	tests := []struct {
+		domainName string
+		want       []string
+	}{
+		{"foo", []string{"foo.example.com", "foo.example.org", "foo"}},
+		{"foo.bar.baz", []string{"foo.bar.baz", "foo.bar.baz.example.com", "foo.bar.baz.example.org"}},
+		{"foo.", []string{"foo."}},
+	}
+	for _, test := range tests {
+		got := resolver.searchCandidates(test.domainName)
+		if !slices.Equal(got, test.want) {
+			t.Fatalf("Got: %v, Want: %v for %s", got, test.want, test.domainName)
+		}
+	}
+	resolver.NDots = 3
+	got := resolver.searchCandidates("foo.bar.baz")
+	want := []string{"foo.bar.baz.example.com", "foo.bar.baz.example.org", "foo.bar.baz"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Got: %v, Want: %v with ndots 3", got, want)
+	}
+}
+
+func TestResolveTriesAbsoluteNameFirstWithEnoughDots(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{answers: []testRecord{aRecord(query.Question.GetDomainName(), "93.184.216.34")}}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, SearchDomains: []string{"example.com"}}
+	_, err := resolver.Resolve("www.example.org")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := receivedQnames(server)
+	if !slices.Equal(got, []string{"www.example.org"}) {
+		t.Fatalf("Got queries: %v, Want: [www.example.org]", got)
+	}
+}
+
+func TestResolveTypeSurfacesTruncation(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{
+			truncated: true,
+			answers:   []testRecord{aRecord("www.example.com", "93.184.216.34")},
+		}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, Cache: &Cache{}}
+	for i := 0; i < 2; i++ {
+		response, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !response.Header.IsTruncatedMessage || len(response.Answers) != 1 {
+			t.Fatalf("Got header: %+v, Want: truncated response with the partial answer", *response.Header)
+		}
+	}
+	if len(server.receivedQueries()) != 2 {
+		t.Fatalf("Got %d queries, Want: truncated response not served from cache", len(server.receivedQueries()))
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	resolver := &Resolver{}
+	tests := map[string]string{
+		"8.8.8.8":                  "8.8.8.8:53",
+		"8.8.8.8:5353":             "8.8.8.8:5353",
+		"2001:4860:4860::8888":     "[2001:4860:4860::8888]:53",
+		"[2001:4860:4860::8888]":   "[2001:4860:4860::8888]:53",
+		"[2001:4860:4860::8888]:5": "[2001:4860:4860::8888]:5",
+	}
+	for server, want := range tests {
+		if got := resolver.serverAddress(server); got != want {
+			t.Fatalf("Got: %s, Want: %s for %s", got, want, server)
+		}
+	}
+}
+
+func TestResolveOverIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	_ = conn.Close()
+	server := startMockServer(t, "[::1]:0", func(query *DnsResponse) []byte {
+		return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	port := server.conn.LocalAddr().(*net.UDPAddr).Port
+	for _, upstream := range []string{server.address(), "::1"} {
+		resolver := &Resolver{Servers: []string{upstream}, Port: port}
+		response, err := resolver.Resolve("www.example.com")
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", upstream, err)
+		}
+		if len(response.Answers) != 1 {
+			t.Fatalf("Got %d answers from %s, Want: 1", len(response.Answers), upstream)
+		}
+	}
+}
+
+func TestCloseLeavesNoGoroutinesBehind(t *testing.T) {
+	var queries atomic.Int32
+	server := startMockTCPServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		// Only the first query is answered, refreshes are left waiting.
+		if queries.Add(1) > 1 {
+			return nil
+		}
+		return testReply{answers: []testRecord{aRecord("www.example.com", "93.184.216.34")}}.bytesFor(query)
+	})
+	before := runtime.NumGoroutine()
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	resolver := &Resolver{
+		Servers:            []string{server.address()},
+		Clock:              clock,
+		Cache:              &Cache{Clock: clock, PrefetchThreshold: 0.1},
+		Cookies:            true,
+		TCP:                true,
+		PipelineTCPQueries: true,
+		// A refresh left running would query again once Close broke its connection,
+		// connecting anew.
+		Retries:      1,
+		RetryBackoff: 10 * time.Millisecond,
+	}
+	_, err := resolver.Resolve("www.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver.mu.Lock()
+	pipeline := resolver.pipelines[server.address()]
+	resolver.mu.Unlock()
+	if pipeline == nil || pipeline.isClosed() {
+		t.Fatalf("Got no open pipelined connection to close")
+	}
+	// Bring the cached answer close to expiry so that the next lookup refreshes it in the
+	// background, against a server that never answers the refresh: Close must stop it.
+	clock.Advance(280 * time.Second)
+	_, err = resolver.Resolve("www.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for queries.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	if err := resolver.Close(); err != nil {
+		t.Fatalf("Unexpected error closing resolver: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close took %v, Want: the refresh stopped right away", elapsed)
+	}
+	if !pipeline.isClosed() {
+		t.Fatalf("Pipelined connection still open after Close")
+	}
+	resolver.mu.Lock()
+	pipelines := len(resolver.pipelines)
+	resolver.mu.Unlock()
+	if pipelines != 0 {
+		t.Fatalf("Got %d pipelined connections after Close, Want: none", pipelines)
+	}
+	// The mock server may still be serving the connection it accepted.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before+1 {
+		t.Fatalf("Got %d goroutines after Close, Want: at most %d", after, before+1)
+	}
+}
+
+func TestResponseReportsUpstreamServer(t *testing.T) {
+	failing := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{rcode: ServerFailure}.bytesFor(query)
+	})
+	answering := startMockServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{Servers: []string{failing.address(), answering.address()}}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Server != answering.address() {
+		t.Fatalf("Got server: %s, Want: %s", response.Server, answering.address())
+	}
+}
+
+func TestResponseReportsAuthoritativeServer(t *testing.T) {
+	hierarchy := startMockHierarchy(t)
+	response, err := hierarchy.newResolver().ResolveIterative("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := hierarchy.example.address(); response.Server != want {
+		t.Fatalf("Got server: %s, Want: %s", response.Server, want)
+	}
+}
+
+func TestSingleFlightCoalescesConcurrentLookups(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		time.Sleep(100 * time.Millisecond)
+		return answerExample(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, SingleFlight: true}
+	start := make(chan struct{})
+	errs := make(chan error, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			response, err := resolver.ResolveType("www.example.com", A)
+			if err == nil && (len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34") {
+				err = fmt.Errorf("got answers: %v", response.Answers)
+			}
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if got := len(server.receivedQueries()); got != 1 {
+		t.Fatalf("Got %d upstream queries, Want: 1", got)
+	}
+}
+
+func TestSingleFlightOutlivesTheCallerThatStartedIt(t *testing.T) {
+	resolver := &Resolver{}
+	release := make(chan struct{})
+	var resolutions atomic.Int32
+	resolve := func(ctx context.Context) (*DnsResponse, error) {
+		resolutions.Add(1)
+		select {
+		case <-release:
+			return &DnsResponse{Header: &DnsHeader{}}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := resolver.resolveShared(ctx, "www.example.com", A, resolve)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Got error: %v, Want: the first caller stops waiting once its context is done", err)
+	}
+	// The resolution the first caller started is still in flight, for the second caller.
+	time.AfterFunc(50*time.Millisecond, func() {
+		close(release)
+	})
+	_, err = resolver.resolveShared(context.Background(), "www.example.com", A, resolve)
+	if err != nil {
+		t.Fatalf("Got error: %v, Want: the shared resolution carries on for the second caller", err)
+	}
+	if got := resolutions.Load(); got != 1 {
+		t.Fatalf("Got %d resolutions, Want: 1 shared by both callers", got)
+	}
+}
+
+func TestMaxAnswersCapsRetainedRecords(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		var answers []testRecord
+		for i := 1; i <= 20; i++ {
+			answers = append(answers, aRecord("www.example.com", "192.0.2."+strconv.Itoa(i)))
+		}
+		return testReply{answers: answers, additionals: answers[:3]}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, MaxAnswers: 5}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 5 || response.Answers[4].Address != "192.0.2.5" {
+		t.Fatalf("Got answers: %v, Want: the first 5", response.Answers)
+	}
+	if len(response.AdditionalRecords) != 3 {
+		t.Fatalf("Got %d additional records, Want: all 3 after the capped answers", len(response.AdditionalRecords))
+	}
+}
+
+// BenchmarkResolve measures a whole lookup against a local server: building the query,
+// sending it over UDP and parsing the response.
+func BenchmarkResolve(b *testing.B) {
+	server := startMockServer(b, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{Servers: []string{server.address()}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			b.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestResolveAllMergesAddresses(t *testing.T) {
+	records := []DnsAnswer{
+		{Domain: "www.example.com", RecordType: A, RecordClass: IN, TTL: 300, Address: "93.184.216.34"},
+		{Domain: "www.example.com", RecordType: AAAA, RecordClass: IN, TTL: 300, Address: "2606:2800:220:1:248:1893:25c8:1946"},
+	}
+	server := startMockServer(t, "127.0.0.1:0", zoneHandler(records))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveAll("www.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 2 || response.Answers[0].RecordType != A || response.Answers[1].RecordType != AAAA {
+		t.Fatalf("Got answers: %v, Want: the A record and then the AAAA record", response.Answers)
+	}
+}
+
+func TestRecursionDesiredRoundTrip(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerExample)
+	for _, recursionDesired := range []bool{false, true} {
+		resolver := &Resolver{Servers: []string{server.address()}, RecursionDesired: &recursionDesired}
+		query, err := ParseResponse(resolver.newQuery(server.address(), "www.example.com", A).GetBytes())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if query.Header.IsRecursionDesired != recursionDesired {
+			t.Fatalf("Got RD %t in the serialized query, Want: %t", query.Header.IsRecursionDesired, recursionDesired)
+		}
+		response, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if response.Header.IsRecursionDesired != recursionDesired {
+			t.Fatalf("Got RD %t echoed by the server, Want: %t", response.Header.IsRecursionDesired, recursionDesired)
+		}
+	}
+	queries := server.receivedQueries()
+	if len(queries) != 2 || queries[0].Header.IsRecursionDesired || !queries[1].Header.IsRecursionDesired {
+		t.Fatalf("Got queries: %v, Want: RD cleared then set", queries)
+	}
+}
+
+// fakePacketConn answers the query written to it with canned bytes built by reply, without
+// touching the network.
+type fakePacketConn struct {
+	net.Conn
+	reply   mockHandler
+	pending []byte
+	closed  bool
+}
+
+func (c *fakePacketConn) Write(b []byte) (int, error) {
+	query, err := ParseResponse(b)
+	if err != nil {
+		return 0, err
+	}
+	c.pending = c.reply(query)
+	return len(b), nil
+}
+
+func (c *fakePacketConn) Read(b []byte) (int, error) {
+	if c.pending == nil {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n := copy(b, c.pending)
+	c.pending = nil
+	return n, nil
+}
+
+func (c *fakePacketConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (c *fakePacketConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestResolveOverProvidedConnection(t *testing.T) {
+	conn := &fakePacketConn{reply: answerExample}
+	var dialed string
+	resolver := &Resolver{
+		Servers: []string{"192.0.2.1"},
+		DialUDP: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = network + " " + address
+			return conn, nil
+		},
+	}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if dialed != "udp 192.0.2.1:53" {
+		t.Fatalf("Got dial: %q, Want: udp 192.0.2.1:53", dialed)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: the canned A record", response.Answers)
+	}
+	if !conn.closed {
+		t.Fatalf("Got connection left open, Want: closed after the exchange")
+	}
+}
+
+func TestResolveRawReturnsReceivedBytes(t *testing.T) {
+	server := startMockServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, raw, err := resolver.ResolveRaw("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 {
+		t.Fatalf("Got answers: %v, Want: one A record", response.Answers)
+	}
+	sent := answerExample(server.receivedQueries()[0])
+	if !bytes.Equal(raw, sent) {
+		t.Fatalf("Got raw bytes: %x, Want: %x", raw, sent)
+	}
+	resolver = &Resolver{StaticHosts: map[string][]string{"www.example.com": {"192.0.2.1"}}}
+	_, raw, err = resolver.ResolveRaw("www.example.com", A)
+	if err != nil || raw != nil {
+		t.Fatalf("Got raw bytes: %x, error: %v, Want: none for a static host", raw, err)
+	}
+}
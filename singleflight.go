@@ -0,0 +1,47 @@
+package dnsresolvr
+
+import "context"
+
+// flight is a resolution in progress that concurrent lookups of the same question wait for.
+type flight struct {
+	done     chan struct{}
+	response *DnsResponse
+	err      error
+}
+
+// resolveShared runs resolve for the question, unless a resolution of the same question is
+// already in progress, and waits for the result. The resolution runs in a context of its own,
+// bounded by TotalTimeout, so that the caller that happened to start it can't cut it short for
+// the others. Each caller stops waiting once its own ctx is done.
+func (r *Resolver) resolveShared(ctx context.Context, domainName string, qtype MessageType, resolve func(context.Context) (*DnsResponse, error)) (*DnsResponse, error) {
+	key := newCacheKey(domainName, qtype, IN)
+	r.mu.Lock()
+	f, ok := r.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		if r.flights == nil {
+			r.flights = make(map[cacheKey]*flight)
+		}
+		r.flights[key] = f
+		go r.fly(key, f, resolve)
+	}
+	r.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.response, f.err
+	case <-ctx.Done():
+		return nil, wrapError(ErrTimeout, ctx.Err())
+	}
+}
+
+// fly runs the shared resolution of flight f and hands its result to the waiting callers.
+func (r *Resolver) fly(key cacheKey, f *flight, resolve func(context.Context) (*DnsResponse, error)) {
+	ctx, cancel := r.resolveContext()
+	defer cancel()
+	f.response, f.err = resolve(ctx)
+
+	r.mu.Lock()
+	delete(r.flights, key)
+	r.mu.Unlock()
+	close(f.done)
+}
@@ -0,0 +1,444 @@
+package dnsresolvr
+
+import (
+	"context"
+	"dnsresolvr/internal/pkg/utils"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const defaultTCPIdleTimeout = 10 * time.Second
+
+// idleConn is a TCP connection kept open for reuse after its last exchange. timer closes it
+// once it has been idle for TCPIdleTimeout, even if no other query comes in.
+type idleConn struct {
+	conn      net.Conn
+	idleSince time.Time
+	timer     *time.Timer
+}
+
+func (r *Resolver) tcpIdleTimeout() time.Duration {
+	if r.TCPIdleTimeout <= 0 {
+		return defaultTCPIdleTimeout
+	}
+	return r.TCPIdleTimeout
+}
+
+// queryDnsTCP sends the query to server over a new TCP connection, made through dialer unless
+// it is nil, and returns the raw response. The exchange is abandoned after timeout or once ctx
+// is done, whichever comes first.
+func queryDnsTCP(ctx context.Context, dialer proxy.Dialer, dnsQuery *DnsQuery, server string, timeout time.Duration) ([]byte, error) {
+	conn, err := dialTCP(ctx, dialer, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	return exchangeTCP(ctx, conn, dnsQuery, timeout)
+}
+
+// dialTCP connects to server through dialer, such as a SOCKS5 proxy, or directly when dialer
+// is nil.
+func dialTCP(ctx context.Context, dialer proxy.Dialer, server string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if dialer == nil {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", server)
+	}
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		return contextDialer.DialContext(ctx, "tcp", server)
+	}
+	return dialer.Dial("tcp", server)
+}
+
+// exchangeTCP writes the query to conn and reads messages until the response to it arrives.
+// Messages are framed by a two byte length prefix (RFC 1035 section 4.2.2).
+func exchangeTCP(ctx context.Context, conn net.Conn, dnsQuery *DnsQuery, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err := conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	queryBytes := dnsQuery.GetBytes()
+	if len(queryBytes) > 65535 {
+		return nil, errors.New("query longer than 65535 bytes")
+	}
+	framedQuery := append(utils.ConvertUint16ToBytesArray(uint16(len(queryBytes))), queryBytes...)
+	_, err = conn.Write(framedQuery)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	for {
+		response, err := readTCPMessage(conn)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		if isResponseToQuery(dnsQuery, response) {
+			return response, nil
+		}
+	}
+}
+
+// readTCPMessage reads one length-prefixed message from conn, up to the 65535 bytes the
+// prefix allows, leaving conn at the start of the next message.
+func readTCPMessage(conn io.Reader) ([]byte, error) {
+	lengthBytes := make([]byte, 2)
+	_, err := io.ReadFull(conn, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, utils.GetUint16FromBytes(lengthBytes))
+	_, err = io.ReadFull(conn, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// contextError prefers the error of ctx over err when ctx is done, since that is what
+// interrupted the exchange. A deadline of ctx that has passed counts as done even before ctx
+// notices it: the connection deadline set to it may expire first.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !deadline.After(time.Now()) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// queryTCP sends the query to server over TCP. When ReuseTCPConnections is set an idle
+// connection to the server is used if there is one, and the connection is kept for the next
+// query afterwards. PipelineTCPQueries shares a single connection between all queries.
+func (r *Resolver) queryTCP(ctx context.Context, dnsQuery *DnsQuery, server string) ([]byte, error) {
+	if r.PipelineTCPQueries {
+		return r.queryPipelined(ctx, dnsQuery, server)
+	}
+	if !r.ReuseTCPConnections {
+		return queryDnsTCP(ctx, r.Proxy, dnsQuery, server, r.timeout())
+	}
+	conn := r.takeIdleConn(server)
+	reused := conn != nil
+	if !reused {
+		var err error
+		conn, err = dialTCP(ctx, r.Proxy, server, r.timeout())
+		if err != nil {
+			return nil, err
+		}
+	}
+	response, err := exchangeTCP(ctx, conn, dnsQuery, r.timeout())
+	if err != nil {
+		_ = conn.Close()
+		// The server may have closed the connection while it sat idle, so a reused one gets
+		// a second chance on a fresh connection.
+		if reused && ctx.Err() == nil {
+			return r.queryTCP(ctx, dnsQuery, server)
+		}
+		return nil, err
+	}
+	r.putIdleConn(server, conn)
+	return response, nil
+}
+
+// takeIdleConn returns the most recently used idle connection to server, closing those that
+// have been idle for longer than TCPIdleTimeout by the resolver's clock. It returns nil when
+// there is none.
+func (r *Resolver) takeIdleConn(server string) net.Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evictIdleConns()
+	conns := r.idleConns[server]
+	if len(conns) == 0 {
+		return nil
+	}
+	idle := conns[len(conns)-1]
+	idle.timer.Stop()
+	r.idleConns[server] = conns[:len(conns)-1]
+	return idle.conn
+}
+
+func (r *Resolver) putIdleConn(server string, conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.idleConns == nil {
+		r.idleConns = make(map[string][]idleConn)
+	}
+	timer := time.AfterFunc(r.tcpIdleTimeout(), func() {
+		r.expireIdleConn(server, conn)
+	})
+	r.idleConns[server] = append(r.idleConns[server], idleConn{conn: conn, idleSince: r.clock().Now(), timer: timer})
+}
+
+// expireIdleConn closes conn if it is still in the pool for server. A connection taken for a
+// query before its timer fired is left alone.
+func (r *Resolver) expireIdleConn(server string, conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conns := r.idleConns[server]
+	for i, idle := range conns {
+		if idle.conn != conn {
+			continue
+		}
+		_ = conn.Close()
+		conns = append(conns[:i], conns[i+1:]...)
+		if len(conns) == 0 {
+			delete(r.idleConns, server)
+		} else {
+			r.idleConns[server] = conns
+		}
+		return
+	}
+}
+
+// evictIdleConns closes the connections idle for longer than TCPIdleTimeout. r.mu must be
+// held.
+func (r *Resolver) evictIdleConns() {
+	now := r.clock().Now()
+	for server, conns := range r.idleConns {
+		kept := conns[:0]
+		for _, idle := range conns {
+			if now.Sub(idle.idleSince) >= r.tcpIdleTimeout() {
+				idle.timer.Stop()
+				_ = idle.conn.Close()
+				continue
+			}
+			kept = append(kept, idle)
+		}
+		if len(kept) == 0 {
+			delete(r.idleConns, server)
+		} else {
+			r.idleConns[server] = kept
+		}
+	}
+}
+
+// closeIdleConns closes every pooled and pipelined connection. r.mu must be held.
+func (r *Resolver) closeIdleConns() error {
+	var err error
+	for _, conns := range r.idleConns {
+		for _, idle := range conns {
+			idle.timer.Stop()
+			err = errors.Join(err, idle.conn.Close())
+		}
+	}
+	r.idleConns = nil
+	for _, pipeline := range r.pipelines {
+		if !pipeline.isClosed() {
+			err = errors.Join(err, pipeline.Close())
+		}
+	}
+	r.pipelines = nil
+	return err
+}
+
+// pipelinedConn carries concurrent queries over one TCP connection (RFC 7766 section 6.2.1.1).
+// Responses may come back in any order and are handed to the waiting query by their ID.
+type pipelinedConn struct {
+	conn net.Conn
+	// idleTimeout is how long the connection may go without receiving a message, with or
+	// without queries waiting, before it is closed. A server that stopped answering, or a
+	// half-open connection, is dropped that way.
+	idleTimeout time.Duration
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	waiters     map[uint16]*pipelineWaiter
+	err         error
+	done        chan struct{}
+}
+
+type pipelineWaiter struct {
+	query    *DnsQuery
+	response chan []byte
+}
+
+// newPipelinedConn starts reading responses from conn until it is closed, fails or receives
+// nothing for idleTimeout.
+func newPipelinedConn(conn net.Conn, idleTimeout time.Duration) *pipelinedConn {
+	p := &pipelinedConn{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		waiters:     make(map[uint16]*pipelineWaiter),
+		done:        make(chan struct{}),
+	}
+	go p.readResponses()
+	return p
+}
+
+func (p *pipelinedConn) readResponses() {
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(p.conn)
+	for {
+		_ = p.conn.SetReadDeadline(time.Now().Add(p.idleTimeout))
+		response, err := readTCPMessage(p.conn)
+		if err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+			close(p.done)
+			return
+		}
+		if len(response) < 2 {
+			continue
+		}
+		p.mu.Lock()
+		id := utils.GetUint16FromBytes(response[:2])
+		waiter, ok := p.waiters[id]
+		if ok && isResponseToQuery(waiter.query, response) {
+			delete(p.waiters, id)
+			waiter.response <- response
+		}
+		p.mu.Unlock()
+	}
+}
+
+// exchange sends the query over the shared connection and waits for its response. A query
+// whose ID is already in flight on the connection is given a fresh random ID first. The wait
+// is abandoned after timeout or once ctx is done, whichever comes first, which leaves the
+// connection to the other queries. Only a failed write closes it.
+func (p *pipelinedConn) exchange(ctx context.Context, dnsQuery *DnsQuery, timeout time.Duration) ([]byte, error) {
+	waiter := &pipelineWaiter{query: dnsQuery, response: make(chan []byte, 1)}
+	id := dnsQuery.Header.Id
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return nil, err
+	}
+	for p.waiters[id] != nil {
+		id = utils.GetRandomUint16()
+	}
+	dnsQuery.Header.Id = id
+	p.waiters[id] = waiter
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.waiters, id)
+		p.mu.Unlock()
+	}()
+
+	queryBytes := dnsQuery.GetBytes()
+	if len(queryBytes) > 65535 {
+		return nil, errors.New("query longer than 65535 bytes")
+	}
+	p.writeMu.Lock()
+	_ = p.conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := p.conn.Write(append(utils.ConvertUint16ToBytesArray(uint16(len(queryBytes))), queryBytes...))
+	p.writeMu.Unlock()
+	if err != nil {
+		_ = p.conn.Close()
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case response := <-waiter.response:
+		return response, nil
+	case <-p.done:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return nil, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// isClosed reports whether the connection has stopped delivering responses.
+func (p *pipelinedConn) isClosed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection and waits for the reading goroutine to stop.
+func (p *pipelinedConn) Close() error {
+	err := p.conn.Close()
+	<-p.done
+	return err
+}
+
+// queryPipelined sends the query over the connection shared by all queries to server.
+func (r *Resolver) queryPipelined(ctx context.Context, dnsQuery *DnsQuery, server string) ([]byte, error) {
+	pipeline, err := r.pipeline(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.exchange(ctx, dnsQuery, r.timeout())
+}
+
+// pipelineDial is a connection being made for the pipelined queries to a server, which
+// queries to the same server wait for rather than dialing again.
+type pipelineDial struct {
+	done     chan struct{}
+	pipeline *pipelinedConn
+	err      error
+	// abandoned is set when the query that dialed gave up, which says nothing about the
+	// server.
+	abandoned bool
+}
+
+// pipeline returns the connection shared by all queries to server, connecting first when
+// there is none or the previous one broke. The dial happens without r.mu held so that a slow
+// server doesn't hold up queries to the others.
+func (r *Resolver) pipeline(ctx context.Context, server string) (*pipelinedConn, error) {
+	r.mu.Lock()
+	if pipeline, ok := r.pipelines[server]; ok && !pipeline.isClosed() {
+		r.mu.Unlock()
+		return pipeline, nil
+	}
+	if dial, ok := r.pipelineDials[server]; ok {
+		r.mu.Unlock()
+		select {
+		case <-dial.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if dial.abandoned {
+			return r.pipeline(ctx, server)
+		}
+		return dial.pipeline, dial.err
+	}
+	dial := &pipelineDial{done: make(chan struct{})}
+	if r.pipelineDials == nil {
+		r.pipelineDials = make(map[string]*pipelineDial)
+	}
+	r.pipelineDials[server] = dial
+	r.mu.Unlock()
+
+	conn, err := dialTCP(ctx, r.Proxy, server, r.timeout())
+	r.mu.Lock()
+	delete(r.pipelineDials, server)
+	if err == nil {
+		dial.pipeline = newPipelinedConn(conn, r.tcpIdleTimeout())
+		if r.pipelines == nil {
+			r.pipelines = make(map[string]*pipelinedConn)
+		}
+		r.pipelines[server] = dial.pipeline
+	}
+	dial.err = err
+	dial.abandoned = err != nil && ctx.Err() != nil
+	r.mu.Unlock()
+	close(dial.done)
+	return dial.pipeline, dial.err
+}
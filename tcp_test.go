@@ -0,0 +1,548 @@
+package dnsresolvr
+
+import (
+	"bytes"
+	"context"
+	"dnsresolvr/internal/pkg/utils"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestResolveOverTCP(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{Servers: []string{server.address()}, TCP: true}
+	for i := 0; i < 2; i++ {
+		response, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+			t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+		}
+	}
+	if got := server.connections.Load(); got != 2 {
+		t.Fatalf("Got %d connections, Want: 2 without reuse", got)
+	}
+}
+
+func TestReuseTCPConnections(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	resolver := &Resolver{
+		Servers:             []string{server.address()},
+		TCP:                 true,
+		ReuseTCPConnections: true,
+		TCPIdleTimeout:      time.Minute,
+		Clock:               clock,
+	}
+	defer func(resolver *Resolver) {
+		_ = resolver.Close()
+	}(resolver)
+	for i := 0; i < 3; i++ {
+		_, err := resolver.ResolveType("www.example.com", A)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if got := server.connections.Load(); got != 1 {
+		t.Fatalf("Got %d connections, Want: 1 reused connection", got)
+	}
+	clock.Advance(time.Minute)
+	_, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := server.connections.Load(); got != 2 {
+		t.Fatalf("Got %d connections, Want: a new connection after the idle one was evicted", got)
+	}
+}
+
+func TestIdleTCPConnectionClosesWithoutFurtherQueries(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	resolver := &Resolver{
+		Servers:             []string{server.address()},
+		TCP:                 true,
+		ReuseTCPConnections: true,
+		TCPIdleTimeout:      50 * time.Millisecond,
+	}
+	defer func(resolver *Resolver) {
+		_ = resolver.Close()
+	}(resolver)
+	_, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver.mu.Lock()
+	conns := resolver.idleConns[server.address()]
+	resolver.mu.Unlock()
+	if len(conns) != 1 {
+		t.Fatalf("Got %d idle connections, Want: 1 pooled connection", len(conns))
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		resolver.mu.Lock()
+		pooled := len(resolver.idleConns)
+		resolver.mu.Unlock()
+		if pooled == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Idle connection still pooled after TCPIdleTimeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = conns[0].conn.Read(make([]byte, 1))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Got %v reading the idle connection, Want: %v", err, net.ErrClosed)
+	}
+}
+
+func TestPipelinedConnMatchesResponsesById(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	addresses := map[string]string{"a.example.com": "192.0.2.1", "b.example.com": "192.0.2.2", "c.example.com": "192.0.2.3"}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func(conn net.Conn) {
+			_ = conn.Close()
+		}(conn)
+		// Wait for all three queries, then answer them in reverse order.
+		var queries []*DnsResponse
+		for len(queries) < 3 {
+			message, err := readTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			query, err := ParseResponse(message)
+			if err != nil {
+				return
+			}
+			queries = append(queries, query)
+		}
+		for i := len(queries) - 1; i >= 0; i-- {
+			name := queries[i].Question.GetDomainName()
+			reply := testReply{answers: []testRecord{aRecord(name, addresses[name])}}.bytesFor(queries[i])
+			_, _ = conn.Write(append(utils.ConvertUint16ToBytesArray(uint16(len(reply))), reply...))
+		}
+		_, _ = readTCPMessage(conn)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	pipeline := newPipelinedConn(conn, defaultTCPIdleTimeout)
+	defer func(pipeline *pipelinedConn) {
+		_ = pipeline.Close()
+	}(pipeline)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(addresses))
+	for name, address := range addresses {
+		wg.Add(1)
+		go func(name string, address string) {
+			defer wg.Done()
+			responseBytes, err := pipeline.exchange(context.Background(), generateDnsQuery(name, A), time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			response, err := ParseResponse(responseBytes)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(response.Answers) != 1 || response.Answers[0].Address != address {
+				t.Errorf("Got answers: %v for %s, Want: %s", response.Answers, name, address)
+			}
+		}(name, address)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPipelinedQueryWithIdInFlightGetsFreshId(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	conn, err := net.Dial("tcp", server.address())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	pipeline := newPipelinedConn(conn, defaultTCPIdleTimeout)
+	defer func(pipeline *pipelinedConn) {
+		_ = pipeline.Close()
+	}(pipeline)
+	query := generateDnsQuery("www.example.com", A)
+	inFlight := query.Header.Id
+	pipeline.mu.Lock()
+	pipeline.waiters[inFlight] = &pipelineWaiter{query: generateDnsQuery("other.example.com", A), response: make(chan []byte, 1)}
+	pipeline.mu.Unlock()
+	responseBytes, err := pipeline.exchange(context.Background(), query, time.Second)
+	if err != nil {
+		t.Fatalf("Got error: %v, Want: the query sent with another ID", err)
+	}
+	if query.Header.Id == inFlight {
+		t.Fatalf("Got ID %d, Want: an ID other than the one in flight", query.Header.Id)
+	}
+	if !isResponseToQuery(query, responseBytes) {
+		t.Fatalf("Got response %x, Want: the response to the query with its new ID", responseBytes)
+	}
+}
+
+func TestPipelineTCPQueriesShareOneConnection(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	before := runtime.NumGoroutine()
+	resolver := &Resolver{Servers: []string{server.address()}, TCP: true, PipelineTCPQueries: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := resolver.ResolveType("www.example.com", A)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := server.connections.Load(); got != 1 {
+		t.Fatalf("Got %d connections, Want: 1 shared connection", got)
+	}
+	if err := resolver.Close(); err != nil {
+		t.Fatalf("Unexpected error closing resolver: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The mock server may still be serving the connection it accepted.
+	if after := runtime.NumGoroutine(); after > before+1 {
+		t.Fatalf("Got %d goroutines after Close, Want: at most %d", after, before+1)
+	}
+}
+
+// blockingDialer dials directly, except to blocked, whose dials wait for unblock to be closed.
+type blockingDialer struct {
+	blocked string
+	unblock chan struct{}
+}
+
+func (d blockingDialer) Dial(network, address string) (net.Conn, error) {
+	if address == d.blocked {
+		<-d.unblock
+	}
+	return net.Dial(network, address)
+}
+
+func TestPipelinedDialDoesNotBlockOtherServers(t *testing.T) {
+	slowServer := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	dialer := blockingDialer{blocked: slowServer.address(), unblock: make(chan struct{})}
+	resolver := &Resolver{TCP: true, PipelineTCPQueries: true, Proxy: dialer}
+	defer func(resolver *Resolver) {
+		_ = resolver.Close()
+	}(resolver)
+	slowQueryDone := make(chan error)
+	go func() {
+		_, err := resolver.exchange(context.Background(), generateDnsQuery("www.example.com", A), slowServer.address())
+		slowQueryDone <- err
+	}()
+	// Give the slow query time to start dialing.
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan error)
+	go func() {
+		_, err := resolver.exchange(context.Background(), generateDnsQuery("www.example.com", A), server.address())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Query to another server waited for the dial to the slow server")
+	}
+	close(dialer.unblock)
+	if err := <-slowQueryDone; err != nil {
+		t.Fatalf("Unexpected error from the slow server: %v", err)
+	}
+}
+
+// startSilentTCPServer accepts connections and reads from them but never replies.
+func startSilentTCPServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func(conn net.Conn) {
+					_ = conn.Close()
+				}(conn)
+				_, _ = io.Copy(io.Discard, conn)
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestQueryDnsTCPTimesOut(t *testing.T) {
+	address := startSilentTCPServer(t)
+	start := time.Now()
+	_, err := queryDnsTCP(context.Background(), nil, generateDnsQuery("www.example.com", A), address, 100*time.Millisecond)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Got error: %v, Want: timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Got timeout after %v, Want: about 100ms", elapsed)
+	}
+}
+
+func TestQueryDnsTCPStopsWhenContextIsDone(t *testing.T) {
+	address := startSilentTCPServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := queryDnsTCP(ctx, nil, generateDnsQuery("www.example.com", A), address, time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Got error: %v, Want: context deadline exceeded", err)
+	}
+}
+
+func TestPipelinedQueryTimeoutKeepsConnection(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		if query.Question.GetDomainName() == "slow.example.com" {
+			return nil
+		}
+		return answerExample(query)
+	})
+	resolver := &Resolver{TCP: true, PipelineTCPQueries: true, Timeout: 100 * time.Millisecond}
+	defer func(resolver *Resolver) {
+		_ = resolver.Close()
+	}(resolver)
+	_, err := resolver.exchange(context.Background(), generateDnsQuery("slow.example.com", A), server.address())
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Got error: %v, Want: deadline exceeded", err)
+	}
+	_, err = resolver.exchange(context.Background(), generateDnsQuery("www.example.com", A), server.address())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := server.connections.Load(); got != 1 {
+		t.Fatalf("Got %d connections, Want: the connection kept after the timeout", got)
+	}
+}
+
+func TestPipelinedConnClosesWhenIdle(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	conn, err := net.Dial("tcp", server.address())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	pipeline := newPipelinedConn(conn, 50*time.Millisecond)
+	_, err = pipeline.exchange(context.Background(), generateDnsQuery("www.example.com", A), time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-pipeline.done:
+	case <-time.After(time.Second):
+		_ = pipeline.Close()
+		t.Fatalf("Pipelined connection still open after being idle")
+	}
+}
+
+func TestPipelinedQueryTimesOut(t *testing.T) {
+	address := startSilentTCPServer(t)
+	resolver := &Resolver{Servers: []string{address}, TCP: true, PipelineTCPQueries: true, Timeout: 100 * time.Millisecond}
+	defer func(resolver *Resolver) {
+		_ = resolver.Close()
+	}(resolver)
+	_, err := resolver.ResolveType("www.example.com", A)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Got error: %v, Want: deadline exceeded", err)
+	}
+}
+
+func BenchmarkTCPQuery(b *testing.B) {
+	server := startMockTCPServer(b, "127.0.0.1:0", answerExample)
+	for _, reuse := range []bool{false, true} {
+		name := "new connection"
+		if reuse {
+			name = "pooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			resolver := &Resolver{Servers: []string{server.address()}, TCP: true, ReuseTCPConnections: reuse}
+			defer func(resolver *Resolver) {
+				_ = resolver.Close()
+			}(resolver)
+			for i := 0; i < b.N; i++ {
+				_, err := resolver.ResolveType("www.example.com", A)
+				if err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// startSocks5Proxy runs a minimal SOCKS5 proxy (RFC 1928) without authentication that only
+// supports CONNECT to IPv4 addresses, and reports the targets it connected to on the
+// returned channel.
+func startSocks5Proxy(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start SOCKS5 proxy: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSocks5(conn, targets)
+		}
+	}()
+	return listener.Addr().String(), targets
+}
+
+func serveSocks5(conn net.Conn, targets chan<- string) {
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil || greeting[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(conn, request); err != nil || request[1] != 1 || request[3] != 1 {
+		return
+	}
+	target := net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(int(utils.GetUint16FromBytes(request[8:10]))))
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func(upstream net.Conn) {
+		_ = upstream.Close()
+	}(upstream)
+	targets <- target
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestResolveThroughSocks5Proxy(t *testing.T) {
+	server := startMockTCPServer(t, "127.0.0.1:0", answerExample)
+	proxyAddress, targets := startSocks5Proxy(t)
+	dialer, err := proxy.SOCKS5("tcp", proxyAddress, nil, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver := &Resolver{Servers: []string{server.address()}, Proxy: dialer}
+	response, err := resolver.ResolveType("www.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+	select {
+	case target := <-targets:
+		if target != server.address() {
+			t.Fatalf("Got proxied connection to %s, Want: %s", target, server.address())
+		}
+	default:
+		t.Fatalf("Query did not go through the proxy")
+	}
+}
+
+func TestResolveLargeResponseOverTCP(t *testing.T) {
+	var answers []testRecord
+	for i := 0; i < 220; i++ {
+		rdata := append([]byte{249}, strings.Repeat(strconv.Itoa(i%10), 249)...)
+		answers = append(answers, testRecord{name: "www.example.com", rtype: TXT, ttl: 300, rdata: rdata})
+	}
+	server := startMockTCPServer(t, "127.0.0.1:0", func(query *DnsResponse) []byte {
+		return testReply{answers: answers}.bytesFor(query)
+	})
+	resolver := &Resolver{Servers: []string{server.address()}, TCP: true}
+	response, err := resolver.ResolveType("www.example.com", TXT)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size := len(response.raw); size < 60000 || size > 65535 {
+		t.Fatalf("Got a %d byte response, Want: about 60KB", size)
+	}
+	if len(response.Answers) != len(answers) {
+		t.Fatalf("Got %d answers, Want: %d", len(response.Answers), len(answers))
+	}
+	if last := response.Answers[len(answers)-1].Txt; len(last) != 1 || last[0] != strings.Repeat("9", 249) {
+		t.Fatalf("Got last answer: %v, Want: the full string", last)
+	}
+}
+
+func TestReadTCPMessagesBackToBack(t *testing.T) {
+	largest := bytes.Repeat([]byte{0xab}, 65535)
+	var stream []byte
+	stream = append(stream, 0xff, 0xff)
+	stream = append(stream, largest...)
+	stream = append(stream, 0, 3, 1, 2, 3)
+	reader := bytes.NewReader(stream)
+	message, err := readTCPMessage(reader)
+	if err != nil || !bytes.Equal(message, largest) {
+		t.Fatalf("Got %d bytes (%v), Want: the 65535 byte message", len(message), err)
+	}
+	message, err = readTCPMessage(reader)
+	if err != nil || !bytes.Equal(message, []byte{1, 2, 3}) {
+		t.Fatalf("Got: %v (%v), Want: the message right after it", message, err)
+	}
+	_, err = readTCPMessage(bytes.NewReader(stream[:1000]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Got error: %v, Want: unexpected EOF for a cut off message", err)
+	}
+}
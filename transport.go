@@ -0,0 +1,86 @@
+package dnsresolvr
+
+import (
+	"context"
+	"dnsresolvr/internal/pkg/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Transport sends a query to a name server and returns the raw response it got back.
+type Transport interface {
+	Exchange(ctx context.Context, query *DnsQuery, server string) ([]byte, error)
+}
+
+type replayKey struct {
+	name   string
+	qtype  MessageType
+	qclass MessageClass
+}
+
+// ReplayTransport answers queries from captured query and response pairs, like the ones
+// written to Resolver.DumpDir, without touching the network. A query is answered with the
+// response captured for the same question, whatever server it is sent to.
+type ReplayTransport struct {
+	responses map[replayKey][]byte
+}
+
+// NewReplayTransport loads the pairs of name.query.bin and name.response.bin files in dir.
+// When several pairs share a question, the one whose file name sorts last wins, which is the
+// latest capture for files written to DumpDir.
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	queryFiles, err := filepath.Glob(filepath.Join(dir, "*.query.bin"))
+	if err != nil {
+		return nil, err
+	}
+	transport := &ReplayTransport{responses: make(map[replayKey][]byte)}
+	for _, queryFile := range queryFiles {
+		queryBytes, err := os.ReadFile(queryFile)
+		if err != nil {
+			return nil, err
+		}
+		query, err := ParseResponse(queryBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid captured query %s: %w", queryFile, err)
+		}
+		responseBytes, err := os.ReadFile(strings.TrimSuffix(queryFile, ".query.bin") + ".response.bin")
+		if err != nil {
+			return nil, err
+		}
+		if query.Question == nil {
+			return nil, fmt.Errorf("captured query %s has no question", queryFile)
+		}
+		if len(responseBytes) < 2 {
+			return nil, fmt.Errorf("captured response for %s is too short", queryFile)
+		}
+		transport.responses[newReplayKey(*query.Question)] = responseBytes
+	}
+	return transport, nil
+}
+
+func newReplayKey(question DnsQueryQuestion) replayKey {
+	return replayKey{
+		name:   strings.ToLower(question.GetDomainName()),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+	}
+}
+
+// Exchange returns the response captured for the question of query, with its ID changed to
+// the one of query.
+func (t *ReplayTransport) Exchange(_ context.Context, query *DnsQuery, server string) ([]byte, error) {
+	if len(query.Questions) == 0 {
+		return nil, errors.New("no captured response for a query without question")
+	}
+	question := query.Questions[0]
+	captured, ok := t.responses[newReplayKey(question)]
+	if !ok {
+		return nil, fmt.Errorf("no captured response for %s %s from server %s", question.GetDomainName(), question.Qtype, server)
+	}
+	response := append([]byte(nil), captured...)
+	copy(response, utils.ConvertUint16ToBytesArray(query.Header.Id))
+	return response, nil
+}
@@ -0,0 +1,39 @@
+package dnsresolvr
+
+import (
+	"testing"
+)
+
+func TestResolveFromReplayTransport(t *testing.T) {
+	transport, err := NewReplayTransport("testdata/replay")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver := &Resolver{Servers: []string{"192.0.2.1"}, Transport: transport}
+	response, err := resolver.ResolveType("WWW.example.com", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].RecordType != CNAME || response.Answers[0].Target != "example.org" {
+		t.Fatalf("Got answers: %v, Want: a CNAME to example.org", response.Answers)
+	}
+	response, err = resolver.ResolveType("example.org", A)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Address != "93.184.216.34" {
+		t.Fatalf("Got answers: %v, Want: 93.184.216.34", response.Answers)
+	}
+}
+
+func TestReplayTransportWithoutCapture(t *testing.T) {
+	transport, err := NewReplayTransport("testdata/replay")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver := &Resolver{Servers: []string{"192.0.2.1"}, Transport: transport}
+	_, err = resolver.ResolveType("www.example.com", AAAA)
+	if err == nil {
+		t.Fatalf("Expected an error for a question that was not captured")
+	}
+}
@@ -0,0 +1,220 @@
+package dnsresolvr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseZoneFile reads records in master file format (RFC 1035 section 5). Only a subset is
+// understood: A, AAAA, CNAME, MX, NS and TXT records in class IN, one record per line, and
+// the $ORIGIN and $TTL directives. Relative names are completed with the origin and "@"
+// stands for the origin itself. A line starting with white space belongs to the owner of the
+// previous record.
+func ParseZoneFile(r io.Reader) ([]DnsAnswer, error) {
+	var records []DnsAnswer
+	origin := ""
+	var defaultTTL uint32
+	hasTTL := false
+	previousOwner := ""
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		fields, err := zoneFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN takes one name", lineNumber)
+			}
+			origin = zoneName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $TTL takes one value", lineNumber)
+			}
+			ttl, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid TTL %q", lineNumber, fields[1])
+			}
+			defaultTTL = uint32(ttl)
+			hasTTL = true
+			continue
+		}
+		record := DnsAnswer{RecordClass: IN, TTL: defaultTTL}
+		if line[0] == ' ' || line[0] == '\t' {
+			if previousOwner == "" {
+				return nil, fmt.Errorf("line %d: record without owner", lineNumber)
+			}
+			record.Domain = previousOwner
+		} else {
+			record.Domain = zoneName(fields[0], origin)
+			fields = fields[1:]
+		}
+		previousOwner = record.Domain
+		// The TTL and the class may come in either order before the type.
+		ttlSet := false
+		for len(fields) > 0 {
+			if ttl, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+				record.TTL = uint32(ttl)
+				ttlSet = true
+			} else if strings.EqualFold(fields[0], "IN") {
+				record.RecordClass = IN
+			} else {
+				break
+			}
+			fields = fields[1:]
+		}
+		if !ttlSet && !hasTTL {
+			return nil, fmt.Errorf("line %d: record without TTL and no $TTL", lineNumber)
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing record type", lineNumber)
+		}
+		err = parseZoneRdata(&record, strings.ToUpper(fields[0]), fields[1:], origin)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func parseZoneRdata(record *DnsAnswer, recordType string, rdata []string, origin string) error {
+	switch recordType {
+	case "A", "AAAA":
+		if len(rdata) != 1 {
+			return fmt.Errorf("%s record takes one address", recordType)
+		}
+		ip := net.ParseIP(rdata[0])
+		if recordType == "A" && (ip == nil || ip.To4() == nil) || recordType == "AAAA" && (ip == nil || ip.To4() != nil) {
+			return fmt.Errorf("invalid address %q for %s record", rdata[0], recordType)
+		}
+		record.RecordType = A
+		if recordType == "AAAA" {
+			record.RecordType = AAAA
+		}
+		record.Address = ip.String()
+	case "CNAME", "NS":
+		if len(rdata) != 1 {
+			return fmt.Errorf("%s record takes one name", recordType)
+		}
+		record.RecordType = CNAME
+		if recordType == "NS" {
+			record.RecordType = NS
+		}
+		record.Target = zoneName(rdata[0], origin)
+	case "MX":
+		if len(rdata) != 2 {
+			return errors.New("MX record takes a preference and a name")
+		}
+		preference, err := strconv.ParseUint(rdata[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid MX preference %q", rdata[0])
+		}
+		record.RecordType = MX
+		record.Mx = &MxData{Preference: uint16(preference), Exchange: zoneName(rdata[1], origin)}
+	case "TXT":
+		if len(rdata) == 0 {
+			return errors.New("TXT record takes at least one string")
+		}
+		record.RecordType = TXT
+		record.Txt = rdata
+	default:
+		return fmt.Errorf("unsupported record type %s", recordType)
+	}
+	return nil
+}
+
+// zoneName completes a relative name with origin. Names are returned without the trailing
+// dot, like the names read from messages.
+func zoneName(name string, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	if origin == "" {
+		return name
+	}
+	return name + "." + origin
+}
+
+// zoneFields splits a line into fields at white space, stopping at a comment. A quoted field
+// may contain white space and semicolons, and backslash escapes the next character or, as
+// \DDD, stands for a byte given in decimal.
+func zoneFields(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		if c == ' ' || c == '\t' {
+			i++
+			continue
+		}
+		if c == ';' {
+			break
+		}
+		if c != '"' {
+			end := strings.IndexAny(line[i:], " \t;")
+			if end < 0 {
+				end = len(line) - i
+			}
+			fields = append(fields, line[i:i+end])
+			i += end
+			continue
+		}
+		field := strings.Builder{}
+		i++
+		for {
+			if i >= len(line) {
+				return nil, errors.New("unterminated quoted string")
+			}
+			c = line[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+3 < len(line) && isDigits(line[i+1:i+4]) {
+				value, _ := strconv.Atoi(line[i+1 : i+4])
+				if value > 255 {
+					return nil, fmt.Errorf("invalid escape \\%s", line[i+1:i+4])
+				}
+				field.WriteByte(byte(value))
+				i += 4
+				continue
+			}
+			if c == '\\' && i+1 < len(line) {
+				i++
+				c = line[i]
+			}
+			field.WriteByte(c)
+			i++
+		}
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
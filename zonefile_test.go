@@ -0,0 +1,59 @@
+package dnsresolvr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testZone = `$ORIGIN example.com.
+$TTL 3600
+; the apex
+@	IN	NS	ns1
+	IN	MX	10 mail.example.com.
+	300 IN	TXT	"v=spf1 -all" "a \"quoted\"; string"
+www	IN	A	93.184.216.34
+www	IN	AAAA	2606:2800:220:1:248:1893:25c8:1946
+ftp	60	CNAME	www ; relative target
+`
+
+func TestParseZoneFile(t *testing.T) {
+	records, err := ParseZoneFile(strings.NewReader(testZone))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []DnsAnswer{
+		{Domain: "example.com", RecordType: NS, RecordClass: IN, TTL: 3600, Target: "ns1.example.com"},
+		{Domain: "example.com", RecordType: MX, RecordClass: IN, TTL: 3600, Mx: &MxData{Preference: 10, Exchange: "mail.example.com"}},
+		{Domain: "example.com", RecordType: TXT, RecordClass: IN, TTL: 300, Txt: []string{"v=spf1 -all", `a "quoted"; string`}},
+		{Domain: "www.example.com", RecordType: A, RecordClass: IN, TTL: 3600, Address: "93.184.216.34"},
+		{Domain: "www.example.com", RecordType: AAAA, RecordClass: IN, TTL: 3600, Address: "2606:2800:220:1:248:1893:25c8:1946"},
+		{Domain: "ftp.example.com", RecordType: CNAME, RecordClass: IN, TTL: 60, Target: "www.example.com"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("Got: %+v, Want: %+v", records, want)
+	}
+}
+
+func TestParseZoneFileRejectsUnsupportedType(t *testing.T) {
+	_, err := ParseZoneFile(strings.NewReader("example.com. 300 IN SOA ns1 admin 1 2 3 4 5\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("Got error: %v, Want: unsupported type on line 1", err)
+	}
+}
+
+func TestMockServerServesZoneFile(t *testing.T) {
+	records, err := ParseZoneFile(strings.NewReader(testZone))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server := startMockServer(t, "127.0.0.1:0", zoneHandler(records))
+	resolver := &Resolver{Servers: []string{server.address()}}
+	response, err := resolver.ResolveType("example.com", MX)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Mx == nil || response.Answers[0].Mx.Exchange != "mail.example.com" {
+		t.Fatalf("Got answers: %+v, Want: MX mail.example.com", response.Answers)
+	}
+}